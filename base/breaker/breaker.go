@@ -0,0 +1,323 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/colin-water/go_tool_libaray/base/internal/window"
+	"github.com/colin-water/go_tool_libaray/base/retry"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOpenState 熔断器处于打开状态，请求被拒绝
+	ErrOpenState = errors.New("breaker: 熔断器处于打开状态")
+	// ErrTooManyRequests 熔断器处于半开状态，探测的请求已经够了
+	ErrTooManyRequests = errors.New("breaker: 熔断器处于半开状态，请求过多")
+)
+
+// State 熔断器的状态
+type State int
+
+const (
+	// StateClosed 关闭，请求正常通过
+	StateClosed State = iota
+	// StateOpen 打开，所有请求都被拒绝
+	StateOpen
+	// StateHalfOpen 半开，放少量请求过去探测下游有没有恢复
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker 熔断器
+// 关闭状态下，连续失败的次数或者滑动窗口里面的失败率达到阈值就会打开；
+// 打开一段时间（由冷却策略决定）之后进入半开状态，放 halfOpenRequests 个请求过去，
+// 全部成功就关闭，有一个失败就重新打开，并且使用冷却策略的下一个间隔
+type Breaker struct {
+	mutex sync.Mutex
+
+	consecutiveFailures int
+	failureRate         float64
+	minRequests         int64
+	halfOpenRequests    int
+	coolDown            retry.Strategy
+	windowSize          time.Duration
+	isFailure           func(err error) bool
+	onStateChange       func(from, to State)
+	now                 func() time.Time
+
+	state State
+	// generation 每次状态变化都会加一，用来忽略上一个状态里面发出去的请求的结果
+	generation uint64
+	// consecutive 关闭状态下连续失败的次数
+	consecutive int
+	window      *window.Window
+	// backoff 这一轮打开的冷却状态，关闭的时候丢弃
+	backoff      retry.Strategy
+	lastCoolDown time.Duration
+	openUntil    time.Time
+	// 半开状态下已经放过去的请求和成功的请求
+	halfOpenInflight  int
+	halfOpenSuccesses int
+}
+
+// Option 熔断器的可选配置
+type Option func(b *Breaker)
+
+// WithConsecutiveFailures 连续失败 n 次之后打开，n 小于等于 0 的时候不按照连续失败打开
+func WithConsecutiveFailures(n int) Option {
+	return func(b *Breaker) {
+		b.consecutiveFailures = n
+	}
+}
+
+// WithFailureRate 滑动窗口 window 里面请求数量不少于 minRequests，并且失败率达到 rate 之后打开
+func WithFailureRate(rate float64, minRequests int64, window time.Duration) Option {
+	return func(b *Breaker) {
+		b.failureRate = rate
+		b.minRequests = minRequests
+		b.windowSize = window
+	}
+}
+
+// WithCoolDown 打开之后多久进入半开状态
+// 每一次打开都会调用 s.Next 拿到冷却时间，所以用指数退避的策略可以让反复失败的下游冷却得越来越久；
+// 关闭之后会重新开始。s 不允许继续的时候沿用上一次的冷却时间
+func WithCoolDown(s retry.Strategy) Option {
+	return func(b *Breaker) {
+		b.coolDown = s
+	}
+}
+
+// WithHalfOpenRequests 半开状态下放过去的请求数量，这些请求全部成功之后关闭
+func WithHalfOpenRequests(n int) Option {
+	return func(b *Breaker) {
+		b.halfOpenRequests = n
+	}
+}
+
+// WithIsFailure 判断错误是不是算作失败，默认所有不为 nil 的错误都算
+// 比如参数错误之类的业务错误，一般不应该让熔断器打开
+func WithIsFailure(fn func(err error) bool) Option {
+	return func(b *Breaker) {
+		b.isFailure = fn
+	}
+}
+
+// WithOnStateChange 状态变化的时候回调，回调是在锁里面同步执行的，不要在里面做耗时的操作
+func WithOnStateChange(fn func(from, to State)) Option {
+	return func(b *Breaker) {
+		b.onStateChange = fn
+	}
+}
+
+// NewBreaker 创建熔断器
+// 默认连续失败 5 次打开，冷却 5 秒，半开状态放一个请求过去
+func NewBreaker(opts ...Option) (*Breaker, error) {
+	b := &Breaker{
+		consecutiveFailures: 5,
+		halfOpenRequests:    1,
+		isFailure: func(err error) bool {
+			return err != nil
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.coolDown == nil {
+		coolDown, err := retry.NewFixedIntervalRetryStrategy(time.Second*5, 0)
+		if err != nil {
+			return nil, err
+		}
+		b.coolDown = coolDown
+	}
+	if b.halfOpenRequests <= 0 {
+		return nil, common.NewErrInvalidArgument("halfOpenRequests", b.halfOpenRequests)
+	}
+	if b.windowSize != 0 || b.failureRate != 0 {
+		if b.failureRate <= 0 || b.failureRate > 1 {
+			return nil, common.NewErrInvalidArgument("failureRate", b.failureRate)
+		}
+		w, err := window.New(b.windowSize)
+		if err != nil {
+			return nil, err
+		}
+		b.window = w
+	}
+	return b, nil
+}
+
+// State 返回当前的状态
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(b.now())
+	return b.state
+}
+
+// Allow 判断请求能不能通过，不能通过的时候返回 ErrOpenState 或者 ErrTooManyRequests
+// 能通过的时候，请求结束之后必须调用 done 上报结果
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(b.now())
+	switch b.state {
+	case StateOpen:
+		return nil, ErrOpenState
+	case StateHalfOpen:
+		if b.halfOpenInflight >= b.halfOpenRequests {
+			return nil, ErrTooManyRequests
+		}
+		b.halfOpenInflight++
+	}
+	generation := b.generation
+	return func(err error) {
+		b.done(generation, err)
+	}, nil
+}
+
+// Execute 熔断器允许的时候执行 fn 并且上报结果
+// 被熔断器拒绝的时候返回的错误用 retry.Permanent 包装过，
+// 所以在 retry.Do 里面调用 Execute，熔断器打开之后会马上停止重试。
+// fn panic 的时候算作一次失败，上报之后继续 panic
+func (b *Breaker) Execute(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	done, err := b.Allow()
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	defer func() {
+		// 不上报的话，半开状态下探测的名额会一直被占着，熔断器再也关不上
+		if r := recover(); r != nil {
+			done(&panicError{value: r})
+			panic(r)
+		}
+		done(err)
+	}()
+	return fn(ctx)
+}
+
+// panicError Execute 里面 fn panic 了，不管 isFailure 怎么判断都算失败
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("breaker: panic: %v", e.value)
+}
+
+// Do 按照重试策略 s 执行 fn，每一次尝试都要经过熔断器，熔断器打开之后不再重试
+func Do(ctx context.Context, b *Breaker, s retry.Strategy, fn func(ctx context.Context) error, opts ...retry.Option) error {
+	return retry.Do(ctx, s, func(ctx context.Context) error {
+		return b.Execute(ctx, fn)
+	}, opts...)
+}
+
+func (b *Breaker) done(generation uint64, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	b.refresh(now)
+	// 状态已经变了，这个结果已经没有意义了
+	if generation != b.generation {
+		return
+	}
+	var pe *panicError
+	failed := errors.As(err, &pe) || b.isFailure(err)
+	switch b.state {
+	case StateClosed:
+		if b.window != nil {
+			var failures int64
+			if failed {
+				failures = 1
+			}
+			b.window.Add(now, 1, failures)
+		}
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		b.consecutive++
+		if b.shouldTrip(now) {
+			b.open(now)
+		}
+	case StateHalfOpen:
+		if failed {
+			b.open(now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.halfOpenRequests {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+func (b *Breaker) shouldTrip(now time.Time) bool {
+	if b.consecutiveFailures > 0 && b.consecutive >= b.consecutiveFailures {
+		return true
+	}
+	if b.window != nil {
+		requests, failures := b.window.Sum(now)
+		if requests > 0 && requests >= b.minRequests &&
+			float64(failures)/float64(requests) >= b.failureRate {
+			return true
+		}
+	}
+	return false
+}
+
+// open 打开熔断器，并且计算冷却时间
+func (b *Breaker) open(now time.Time) {
+	if b.backoff == nil {
+		b.backoff = retry.Start(b.coolDown)
+	}
+	if interval, ok := b.backoff.Next(); ok {
+		b.lastCoolDown = interval
+	}
+	b.openUntil = now.Add(b.lastCoolDown)
+	b.setState(StateOpen)
+}
+
+// refresh 冷却时间到了之后进入半开状态
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == StateOpen && !now.Before(b.openUntil) {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	prev := b.state
+	b.state = state
+	b.generation++
+	b.consecutive = 0
+	b.halfOpenInflight = 0
+	b.halfOpenSuccesses = 0
+	if b.window != nil {
+		b.window.Reset()
+	}
+	if state == StateClosed {
+		b.backoff = nil
+		b.lastCoolDown = 0
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(prev, state)
+	}
+}
@@ -0,0 +1,254 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/colin-water/go_tool_libaray/base/internal/window"
+	"github.com/colin-water/go_tool_libaray/base/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errDownstream = errors.New("downstream")
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newBreakerForTest(t *testing.T, opts ...Option) (*Breaker, *fakeClock) {
+	b, err := NewBreaker(opts...)
+	require.NoError(t, err)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b.now = clock.Now
+	return b, clock
+}
+
+func fail(context.Context) error {
+	return errDownstream
+}
+
+func succeed(context.Context) error {
+	return nil
+}
+
+func TestNewBreaker(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{name: "default"},
+		{
+			name:    "half open requests",
+			opts:    []Option{WithHalfOpenRequests(0)},
+			wantErr: common.NewErrInvalidArgument("halfOpenRequests", 0),
+		},
+		{
+			name:    "failure rate",
+			opts:    []Option{WithFailureRate(1.5, 10, time.Second)},
+			wantErr: common.NewErrInvalidArgument("failureRate", 1.5),
+		},
+		{
+			name:    "window too small",
+			opts:    []Option{WithFailureRate(0.5, 10, window.Buckets-1)},
+			wantErr: common.NewErrInvalidArgument("window", time.Duration(window.Buckets-1)),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewBreaker(tc.opts...)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestBreaker_StateTransitions(t *testing.T) {
+	var transitions []State
+	coolDown, err := retry.NewExponentialBackoffRetryStrategy(time.Second, time.Minute, 0)
+	require.NoError(t, err)
+	b, clock := newBreakerForTest(t,
+		WithConsecutiveFailures(3),
+		WithCoolDown(coolDown),
+		WithHalfOpenRequests(2),
+		WithOnStateChange(func(from, to State) {
+			transitions = append(transitions, to)
+		}))
+	ctx := context.Background()
+
+	// 成功会打断连续失败
+	assert.Equal(t, errDownstream, b.Execute(ctx, fail))
+	assert.Equal(t, errDownstream, b.Execute(ctx, fail))
+	require.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, errDownstream, b.Execute(ctx, fail))
+	assert.Equal(t, errDownstream, b.Execute(ctx, fail))
+	assert.Equal(t, StateClosed, b.State())
+
+	// closed -> open
+	assert.Equal(t, errDownstream, b.Execute(ctx, fail))
+	assert.Equal(t, StateOpen, b.State())
+	err = b.Execute(ctx, succeed)
+	assert.ErrorIs(t, err, ErrOpenState)
+	var pe *retry.PermanentError
+	assert.ErrorAs(t, err, &pe)
+
+	// open -> half-open，只放 2 个请求过去
+	clock.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	done1, err := b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	assert.Equal(t, ErrTooManyRequests, err)
+
+	// half-open 有一个失败就重新打开，冷却时间变长
+	done1(errDownstream)
+	assert.Equal(t, StateOpen, b.State())
+	clock.Add(time.Second)
+	assert.Equal(t, StateOpen, b.State())
+	clock.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+
+	// half-open 全部成功之后 closed
+	require.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, StateHalfOpen, b.State())
+	require.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, transitions)
+
+	// 关闭之后冷却时间从头开始
+	for i := 0; i < 3; i++ {
+		_ = b.Execute(ctx, fail)
+	}
+	assert.Equal(t, StateOpen, b.State())
+	clock.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func TestBreaker_StaleResult(t *testing.T) {
+	b, clock := newBreakerForTest(t, WithConsecutiveFailures(1), WithCoolDown(mustFixed(t, time.Second)))
+
+	// 关闭状态下发出去的请求，在熔断器打开之后才返回，结果被忽略
+	done, err := b.Allow()
+	require.NoError(t, err)
+	_ = b.Execute(context.Background(), fail)
+	assert.Equal(t, StateOpen, b.State())
+	clock.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	done(errDownstream)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func TestBreaker_FailureRate(t *testing.T) {
+	b, clock := newBreakerForTest(t,
+		WithConsecutiveFailures(0),
+		WithFailureRate(0.5, 10, time.Second),
+		WithCoolDown(mustFixed(t, time.Second)))
+	ctx := context.Background()
+
+	// 请求数量不够的时候不会打开
+	for i := 0; i < 9; i++ {
+		_ = b.Execute(ctx, fail)
+	}
+	assert.Equal(t, StateClosed, b.State())
+
+	// 窗口滑过去之后，之前的失败不再统计
+	clock.Add(time.Second)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, b.Execute(ctx, succeed))
+	}
+	for i := 0; i < 3; i++ {
+		_ = b.Execute(ctx, fail)
+	}
+	assert.Equal(t, StateClosed, b.State())
+
+	// 10 个请求里面 4 个失败，还没到 50%
+	clock.Add(time.Millisecond * 500)
+	_ = b.Execute(ctx, fail)
+	assert.Equal(t, StateClosed, b.State())
+	_ = b.Execute(ctx, fail)
+	assert.Equal(t, StateClosed, b.State())
+	// 12 个请求里面 6 个失败
+	_ = b.Execute(ctx, fail)
+	assert.Equal(t, StateOpen, b.State())
+}
+
+func TestBreaker_IsFailure(t *testing.T) {
+	errBadRequest := errors.New("bad request")
+	b, _ := newBreakerForTest(t,
+		WithConsecutiveFailures(1),
+		WithIsFailure(func(err error) bool {
+			return err != nil && !errors.Is(err, errBadRequest)
+		}))
+
+	// 业务错误不算失败
+	assert.Equal(t, errBadRequest, b.Execute(context.Background(), func(ctx context.Context) error {
+		return errBadRequest
+	}))
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestBreaker_Panic(t *testing.T) {
+	b, clock := newBreakerForTest(t,
+		WithConsecutiveFailures(1),
+		WithCoolDown(mustFixed(t, time.Second)),
+		// panic 不管 isFailure 怎么判断都算失败
+		WithIsFailure(func(err error) bool {
+			return false
+		}))
+	ctx := context.Background()
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = b.Execute(ctx, func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, StateOpen, b.State())
+
+	// 半开状态下探测的请求 panic 了，名额要还回来
+	clock.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Panics(t, func() {
+		_ = b.Execute(ctx, func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, StateOpen, b.State())
+	clock.Add(time.Second)
+	require.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestDo(t *testing.T) {
+	b, _ := newBreakerForTest(t, WithConsecutiveFailures(2), WithCoolDown(mustFixed(t, time.Second)))
+
+	// 熔断器打开之后马上停止重试
+	attempts := 0
+	err := Do(context.Background(), b, mustFixed(t, time.Millisecond), func(ctx context.Context) error {
+		attempts++
+		return errDownstream
+	})
+	assert.Equal(t, 2, attempts)
+	assert.ErrorIs(t, err, ErrOpenState)
+	assert.ErrorIs(t, err, errDownstream)
+}
+
+func mustFixed(t *testing.T, interval time.Duration) retry.Strategy {
+	s, err := retry.NewFixedIntervalRetryStrategy(interval, 0)
+	require.NoError(t, err)
+	return s
+}
@@ -29,3 +29,8 @@ func NewErrWithMessage(message string) error {
 
 	return errors.New(message)
 }
+
+// NewErrInvalidArgument 创建一个代表参数不合法的错误
+func NewErrInvalidArgument(name string, value any) error {
+	return fmt.Errorf("无效的参数 %s: %v", name, value)
+}
@@ -0,0 +1,65 @@
+package window
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"time"
+)
+
+// Buckets 滑动窗口被切分成的桶的数量
+const Buckets = 10
+
+type bucket struct {
+	start    int64 // 桶的开始时间
+	requests int64
+	failures int64
+}
+
+// Window 按照时间切分成桶的滑动窗口，统计请求和失败的数量
+// 熔断器和自适应的重试预算都用它来统计最近一段时间的失败率。
+// Window 不是并发安全的，由调用方加锁
+type Window struct {
+	bucketSize time.Duration
+	buckets    [Buckets]bucket
+}
+
+// New 创建大小为 size 的滑动窗口，每个桶至少 1ns，所以 size 不能小于 Buckets
+func New(size time.Duration) (*Window, error) {
+	if size < Buckets {
+		return nil, common.NewErrInvalidArgument("window", size)
+	}
+	return &Window{bucketSize: size / Buckets}, nil
+}
+
+// Add 在 now 所在的桶里面加上 requests 个请求和 failures 个失败，桶已经过期的时候会被清空
+func (w *Window) Add(now time.Time, requests int64, failures int64) {
+	start := w.bucketStart(now)
+	b := &w.buckets[(start/int64(w.bucketSize))%Buckets]
+	if b.start != start {
+		*b = bucket{start: start}
+	}
+	b.requests += requests
+	b.failures += failures
+}
+
+// Sum 返回截止到 now 的窗口里面请求和失败的数量
+func (w *Window) Sum(now time.Time) (requests int64, failures int64) {
+	// 只统计窗口以内的桶
+	cutoff := w.bucketStart(now) - int64(w.bucketSize)*(Buckets-1)
+	for _, b := range w.buckets {
+		if b.start >= cutoff {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// Reset 清空所有的桶
+func (w *Window) Reset() {
+	w.buckets = [Buckets]bucket{}
+}
+
+func (w *Window) bucketStart(t time.Time) int64 {
+	now := t.UnixNano()
+	return now - now%int64(w.bucketSize)
+}
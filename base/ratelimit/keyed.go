@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/mapx"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter 按照 key 限流，每个 key 有自己的 Limiter，比如每个用户、每个 IP 单独限流
+// 一段时间没有被用到的 key 会被清理掉，避免 key 越来越多。
+// 清理是在调用的时候顺便做的，不会启动后台的 goroutine
+type KeyedLimiter[K comparable] struct {
+	mutex    sync.Mutex
+	factory  func() Limiter
+	limiters *mapx.BuiltinMap[K, *keyedEntry]
+	// idleTimeout 超过这个时间没有被用到的 key 会被清理，小于等于 0 的时候不清理
+	idleTimeout time.Duration
+	lastEvict   time.Time
+}
+
+type keyedEntry struct {
+	limiter Limiter
+	// lastUsed 最后一次用到的时间，预定的请求会算到它的执行时间
+	lastUsed time.Time
+}
+
+// NewKeyedLimiter 创建按照 key 限流的限流器，factory 用来给新的 key 创建 Limiter
+// 被清理掉的 key 下一次用到的时候会重新创建，所以 idleTimeout 应该比限流的窗口长
+func NewKeyedLimiter[K comparable](factory func() Limiter, idleTimeout time.Duration) *KeyedLimiter[K] {
+	return &KeyedLimiter[K]{
+		factory:     factory,
+		limiters:    mapx.NewBuiltinMap[K, *keyedEntry](16),
+		idleTimeout: idleTimeout,
+		lastEvict:   time.Now(),
+	}
+}
+
+// Allow 参考 Limiter.Allow
+func (k *KeyedLimiter[K]) Allow(key K) bool {
+	return k.get(key, time.Now()).Allow()
+}
+
+// Wait 参考 Limiter.Wait
+// 和 Reserve 一样，等待中的 key 到执行时间之前不会被清理。
+// factory 返回的不是这个包里面的限流器的时候做不到这一点，等待的时间应该小于 idleTimeout
+func (k *KeyedLimiter[K]) Wait(ctx context.Context, key K) error {
+	l := k.get(key, time.Now())
+	r, ok := l.(reserver)
+	if !ok {
+		return l.Wait(ctx)
+	}
+	return wait(ctx, keyedReserver[K]{reserver: r, keyed: k, key: key})
+}
+
+// Reserve 参考 Limiter.Reserve
+func (k *KeyedLimiter[K]) Reserve(key K) *Reservation {
+	r := k.get(key, time.Now()).Reserve()
+	k.touch(key, r)
+	return r
+}
+
+// Len 返回当前 key 的数量
+func (k *KeyedLimiter[K]) Len() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return int(k.limiters.Len())
+}
+
+// Evict 清理超过 idleTimeout 没有被用到的 key，返回被清理的数量
+func (k *KeyedLimiter[K]) Evict() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.evict(time.Now())
+}
+
+func (k *KeyedLimiter[K]) get(key K, now time.Time) Limiter {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	// 每隔 idleTimeout 顺便清理一次
+	if k.idleTimeout > 0 && now.Sub(k.lastEvict) >= k.idleTimeout {
+		k.evict(now)
+	}
+	entry, ok := k.limiters.Get(key)
+	if !ok {
+		entry = &keyedEntry{limiter: k.factory()}
+		_ = k.limiters.Put(key, entry)
+	}
+	if now.After(entry.lastUsed) {
+		entry.lastUsed = now
+	}
+	return entry.limiter
+}
+
+func (k *KeyedLimiter[K]) evict(now time.Time) int {
+	k.lastEvict = now
+	if k.idleTimeout <= 0 {
+		return 0
+	}
+	cnt := 0
+	for _, key := range k.limiters.Keys() {
+		entry, _ := k.limiters.Get(key)
+		if now.Sub(entry.lastUsed) >= k.idleTimeout {
+			k.limiters.Delete(key)
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// touch 预定成功的时候把 key 最后用到的时间推到预定的执行时间，在这之前 key 不会被清理
+func (k *KeyedLimiter[K]) touch(key K, r *Reservation) {
+	if !r.OK() {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if entry, ok := k.limiters.Get(key); ok && r.timeToAct.After(entry.lastUsed) {
+		entry.lastUsed = r.timeToAct
+	}
+}
+
+// keyedReserver 预定的时候同时更新 key 最后用到的时间，给 Wait 使用
+type keyedReserver[K comparable] struct {
+	reserver
+	keyed *KeyedLimiter[K]
+	key   K
+}
+
+func (k keyedReserver[K]) reserve(now time.Time, maxDelay time.Duration) *Reservation {
+	r := k.reserver.reserve(now, maxDelay)
+	k.keyed.touch(k.key, r)
+	return r
+}
+
+var (
+	_ Limiter = &TokenBucket{}
+	_ Limiter = &SlidingWindowLog{}
+	_ Limiter = &SlidingWindowCounter{}
+	_ Limiter = &LeakyBucket{}
+)
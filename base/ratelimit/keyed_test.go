@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newKeyedForTest(t *testing.T, idleTimeout time.Duration) *KeyedLimiter[string] {
+	return NewKeyedLimiter[string](func() Limiter {
+		l, err := NewLeakyBucket(1, 10)
+		require.NoError(t, err)
+		return l
+	}, idleTimeout)
+}
+
+func TestKeyedLimiter_Evict(t *testing.T) {
+	k := newKeyedForTest(t, time.Minute)
+	now := k.lastEvict
+
+	a := k.get("a", now)
+	// 同一个 key 用的是同一个 Limiter
+	assert.Same(t, a, k.get("a", now))
+	k.get("b", now.Add(time.Second*30))
+	assert.Equal(t, 2, k.Len())
+
+	// 每隔 idleTimeout 在调用的时候顺便清理
+	k.get("b", now.Add(time.Second*59))
+	assert.Equal(t, 2, k.Len())
+	k.get("b", now.Add(time.Second*61))
+	assert.Equal(t, 1, k.Len())
+	// 被清理掉的 key 重新创建
+	assert.NotSame(t, a, k.get("a", now.Add(time.Second*61)))
+
+	assert.Equal(t, 0, k.evict(now.Add(time.Second*90)))
+	assert.Equal(t, 2, k.evict(now.Add(time.Second*200)))
+	assert.Equal(t, 0, k.Len())
+}
+
+func TestKeyedLimiter_NoEvict(t *testing.T) {
+	k := newKeyedForTest(t, 0)
+	now := k.lastEvict
+	k.get("a", now)
+	k.get("b", now.Add(time.Hour))
+	assert.Equal(t, 0, k.evict(now.Add(time.Hour*2)))
+	assert.Equal(t, 2, k.Len())
+}
+
+func TestKeyedLimiter_Reserve(t *testing.T) {
+	k := newKeyedForTest(t, time.Second*2)
+
+	// 每个 key 单独限流
+	assert.True(t, k.Allow("a"))
+	assert.False(t, k.Allow("a"))
+	assert.True(t, k.Allow("b"))
+
+	// 预定到将来的请求，到执行之前 key 不会被清理
+	var r *Reservation
+	for i := 0; i < 5; i++ {
+		r = k.Reserve("c")
+		require.True(t, r.OK())
+	}
+	assert.Greater(t, r.Delay(), time.Second*3)
+	k.mutex.Lock()
+	assert.Equal(t, 2, k.evict(time.Now().Add(time.Second*3)))
+	k.mutex.Unlock()
+	assert.Equal(t, 1, k.Len())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.Equal(t, ErrExceedsDeadline, k.Wait(ctx, "c"))
+}
+
+func TestKeyedLimiter_WaitTouch(t *testing.T) {
+	k := newKeyedForTest(t, time.Second*2)
+	now := time.Now()
+	// 直接在底层的限流器上预定，不更新最后用到的时间
+	l := k.get("a", now)
+	for i := 0; i < 4; i++ {
+		require.True(t, l.Reserve().OK())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- k.Wait(ctx, "a")
+	}()
+	// 等待中的 key 最后用到的时间是预定的执行时间
+	require.Eventually(t, func() bool {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+		entry, _ := k.limiters.Get("a")
+		return entry.lastUsed.After(now.Add(time.Second * 3))
+	}, time.Second, time.Millisecond)
+	k.mutex.Lock()
+	assert.Equal(t, 0, k.evict(now.Add(time.Second*3)))
+	k.mutex.Unlock()
+	assert.Equal(t, 1, k.Len())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
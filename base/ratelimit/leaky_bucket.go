@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"sync"
+	"time"
+)
+
+// LeakyBucket 漏桶
+// 请求进入桶里面排队，以固定的速度流出，流出的请求之间的间隔是 1 / rate 秒，没有突发流量。
+// 排队的请求超过 capacity 之后，新的请求会被拒绝
+type LeakyBucket struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	capacity int
+	// next 下一个请求最早的流出时间
+	next time.Time
+}
+
+// NewLeakyBucket 创建漏桶，rate 是每秒流出的请求数量，capacity 是最多排队的请求数量
+// capacity 是 0 的时候不排队，只能通过 Allow 判断当前能不能通过。
+// 流出的间隔最小是 1ns，所以 rate 不能超过 1e9
+func NewLeakyBucket(rate float64, capacity int) (*LeakyBucket, error) {
+	interval := time.Duration(float64(time.Second) / rate)
+	if rate <= 0 || interval <= 0 {
+		return nil, common.NewErrInvalidArgument("rate", rate)
+	}
+	if capacity < 0 {
+		return nil, common.NewErrInvalidArgument("capacity", capacity)
+	}
+	return &LeakyBucket{
+		interval: interval,
+		capacity: capacity,
+	}, nil
+}
+
+func (l *LeakyBucket) Allow() bool {
+	return allow(l)
+}
+
+func (l *LeakyBucket) Wait(ctx context.Context) error {
+	return wait(ctx, l)
+}
+
+func (l *LeakyBucket) Reserve() *Reservation {
+	return reserve(l)
+}
+
+func (l *LeakyBucket) reserve(now time.Time, maxDelay time.Duration) *Reservation {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	timeToAct := l.next
+	if timeToAct.Before(now) {
+		timeToAct = now
+	}
+	delay := timeToAct.Sub(now)
+	// 前面还有多少个请求在排队
+	if waiting := int((delay + l.interval - 1) / l.interval); waiting > l.capacity {
+		return rejected()
+	}
+	if delay > maxDelay {
+		return tooLate(timeToAct)
+	}
+	l.next = timeToAct.Add(l.interval)
+	return &Reservation{
+		ok:        true,
+		timeToAct: timeToAct,
+		cancel: func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			// 只有最后一个排队的请求能够把位置让出来，否则后面的请求的时间都要变
+			if l.next.Equal(timeToAct.Add(l.interval)) {
+				l.next = timeToAct
+			}
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewLeakyBucket(t *testing.T) {
+	_, err := NewLeakyBucket(0, 1)
+	assert.Equal(t, common.NewErrInvalidArgument("rate", float64(0)), err)
+	// 流出的间隔不足 1ns
+	_, err = NewLeakyBucket(2e9, 1)
+	assert.Equal(t, common.NewErrInvalidArgument("rate", 2e9), err)
+	lb, err := NewLeakyBucket(1e9, 1)
+	require.NoError(t, err)
+	assert.True(t, lb.Allow())
+	_, err = NewLeakyBucket(1, -1)
+	assert.Equal(t, common.NewErrInvalidArgument("capacity", -1), err)
+}
+
+func TestLeakyBucket_Reserve(t *testing.T) {
+	lb, err := NewLeakyBucket(10, 2)
+	require.NoError(t, err)
+	now := testNow()
+
+	// 以固定的间隔流出，最多排队 capacity 个请求
+	for i := 0; i < 3; i++ {
+		r := lb.reserve(now, infDuration)
+		require.True(t, r.OK())
+		assert.Equal(t, now.Add(time.Millisecond*100*time.Duration(i)), r.timeToAct)
+	}
+	r := lb.reserve(now, infDuration)
+	assert.False(t, r.OK())
+	assert.True(t, r.timeToAct.IsZero())
+
+	// 排队的请求流出之后又有位置了
+	r = lb.reserve(now.Add(time.Millisecond*100), infDuration)
+	require.True(t, r.OK())
+	assert.Equal(t, now.Add(time.Millisecond*300), r.timeToAct)
+
+	// 最后一个排队的请求取消之后，位置让出来
+	r.Cancel()
+	r = lb.reserve(now.Add(time.Millisecond*100), infDuration)
+	require.True(t, r.OK())
+	assert.Equal(t, now.Add(time.Millisecond*300), r.timeToAct)
+}
+
+func TestLeakyBucket_MaxDelay(t *testing.T) {
+	lb, err := NewLeakyBucket(10, 2)
+	require.NoError(t, err)
+	now := testNow()
+
+	assert.True(t, lb.reserve(now, 0).OK())
+	r := lb.reserve(now, 0)
+	assert.False(t, r.OK())
+	assert.Equal(t, now.Add(time.Millisecond*100), r.timeToAct)
+	// 不愿意等的请求不占用位置
+	r = lb.reserve(now, time.Millisecond*100)
+	require.True(t, r.OK())
+	assert.Equal(t, now.Add(time.Millisecond*100), r.timeToAct)
+
+	// capacity 是 0 的时候不排队
+	lb, err = NewLeakyBucket(10, 0)
+	require.NoError(t, err)
+	assert.True(t, lb.reserve(now, infDuration).OK())
+	assert.False(t, lb.reserve(now, infDuration).OK())
+	assert.True(t, lb.reserve(now.Add(time.Millisecond*100), infDuration).OK())
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"sync"
+	"time"
+)
+
+// SlidingWindowCounter 滑动窗口计数
+// 只记录当前固定窗口和上一个固定窗口的请求数量，
+// 用 上一个窗口的数量 * 上一个窗口还在滑动窗口里面的比例 + 当前窗口的数量 来估算滑动窗口里面的请求数量。
+// 内存占用是常数，但是结果是估算的
+type SlidingWindowCounter struct {
+	mutex  sync.Mutex
+	limit  int64
+	window time.Duration
+	// counts 每个固定窗口的请求数量，key 是窗口的编号
+	// 预定的请求可能落在下一个窗口，所以不止两个
+	counts map[int64]int64
+}
+
+// NewSlidingWindowCounter 创建滑动窗口计数，window 时间里面最多 limit 个请求
+func NewSlidingWindowCounter(limit int, window time.Duration) (*SlidingWindowCounter, error) {
+	if limit <= 0 {
+		return nil, common.NewErrInvalidArgument("limit", limit)
+	}
+	if window <= 0 {
+		return nil, common.NewErrInvalidIntervalValue(window)
+	}
+	return &SlidingWindowCounter{
+		limit:  int64(limit),
+		window: window,
+		counts: make(map[int64]int64, 3),
+	}, nil
+}
+
+func (s *SlidingWindowCounter) Allow() bool {
+	return allow(s)
+}
+
+func (s *SlidingWindowCounter) Wait(ctx context.Context) error {
+	return wait(ctx, s)
+}
+
+func (s *SlidingWindowCounter) Reserve() *Reservation {
+	return reserve(s)
+}
+
+func (s *SlidingWindowCounter) reserve(now time.Time, maxDelay time.Duration) *Reservation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current := now.UnixNano() / int64(s.window)
+	// 清理更早的窗口
+	for idx := range s.counts {
+		if idx < current-1 {
+			delete(s.counts, idx)
+		}
+	}
+
+	// 从当前窗口开始，找到最早能容纳这个请求的时间
+	for idx := current; ; idx++ {
+		count := s.counts[idx]
+		if count >= s.limit {
+			continue
+		}
+		// 估算值 prev * (1 - elapsed) + count + 1 <= limit，算出最少要过去的比例
+		elapsed := 0.0
+		if prev := s.counts[idx-1]; prev > 0 {
+			elapsed = 1 - float64(s.limit-count-1)/float64(prev)
+		}
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		start := time.Unix(0, idx*int64(s.window))
+		timeToAct := start.Add(time.Duration(elapsed * float64(s.window)))
+		if timeToAct.Before(now) {
+			timeToAct = now
+		}
+		if timeToAct.Sub(start) >= s.window {
+			continue
+		}
+		if timeToAct.Sub(now) > maxDelay {
+			return tooLate(timeToAct)
+		}
+		s.counts[idx]++
+		return &Reservation{
+			ok:        true,
+			timeToAct: timeToAct,
+			cancel: func() {
+				s.mutex.Lock()
+				defer s.mutex.Unlock()
+				if s.counts[idx] > 0 {
+					s.counts[idx]--
+				}
+			},
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewSlidingWindowCounter(t *testing.T) {
+	_, err := NewSlidingWindowCounter(0, time.Second)
+	assert.Equal(t, common.NewErrInvalidArgument("limit", 0), err)
+	_, err = NewSlidingWindowCounter(1, 0)
+	assert.Equal(t, common.NewErrInvalidIntervalValue(0), err)
+}
+
+func TestSlidingWindowCounter_Reserve(t *testing.T) {
+	s, err := NewSlidingWindowCounter(10, time.Second)
+	require.NoError(t, err)
+	// 对齐到固定窗口的开始
+	now := testNow()
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, s.reserve(now, 0).OK())
+	}
+	r := s.reserve(now.Add(time.Millisecond*500), 0)
+	assert.False(t, r.OK())
+	// 下一个窗口一开始，上一个窗口的 10 个请求还都算在里面
+	assert.WithinDuration(t, now.Add(time.Millisecond*1100), r.timeToAct, time.Millisecond)
+
+	// 下一个窗口过去一半的时候，上一个窗口的请求算一半，还能再通过 5 个
+	next := now.Add(time.Millisecond * 1500)
+	for i := 0; i < 5; i++ {
+		assert.True(t, s.reserve(next, 0).OK())
+	}
+	r = s.reserve(next, 0)
+	assert.False(t, r.OK())
+	assert.WithinDuration(t, now.Add(time.Millisecond*1600), r.timeToAct, time.Millisecond)
+
+	// 愿意等的请求预定到将来
+	r = s.reserve(next, infDuration)
+	require.True(t, r.OK())
+	assert.WithinDuration(t, now.Add(time.Millisecond*1600), r.timeToAct, time.Millisecond)
+	// 取消之后配额还回来
+	r.Cancel()
+	r = s.reserve(next, infDuration)
+	require.True(t, r.OK())
+	assert.WithinDuration(t, now.Add(time.Millisecond*1600), r.timeToAct, time.Millisecond)
+
+	// 很久之前的窗口会被清理
+	s.reserve(now.Add(time.Hour), 0)
+	assert.Len(t, s.counts, 1)
+}
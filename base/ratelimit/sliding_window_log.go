@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog 滑动窗口日志
+// 记录每一个请求的时间，任意 window 长度的时间段里面最多 limit 个请求。
+// 结果是精确的，但是要保存 limit 个时间戳
+type SlidingWindowLog struct {
+	mutex  sync.Mutex
+	limit  int
+	window time.Duration
+	// logs 请求的执行时间，从早到晚，预定的请求的执行时间可能在将来
+	logs []time.Time
+}
+
+// NewSlidingWindowLog 创建滑动窗口日志，window 时间里面最多 limit 个请求
+func NewSlidingWindowLog(limit int, window time.Duration) (*SlidingWindowLog, error) {
+	if limit <= 0 {
+		return nil, common.NewErrInvalidArgument("limit", limit)
+	}
+	if window <= 0 {
+		return nil, common.NewErrInvalidIntervalValue(window)
+	}
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		logs:   make([]time.Time, 0, limit),
+	}, nil
+}
+
+func (s *SlidingWindowLog) Allow() bool {
+	return allow(s)
+}
+
+func (s *SlidingWindowLog) Wait(ctx context.Context) error {
+	return wait(ctx, s)
+}
+
+func (s *SlidingWindowLog) Reserve() *Reservation {
+	return reserve(s)
+}
+
+func (s *SlidingWindowLog) reserve(now time.Time, maxDelay time.Duration) *Reservation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// 清理已经滑出窗口的记录
+	cutoff := now.Add(-s.window)
+	idx := sort.Search(len(s.logs), func(i int) bool {
+		return s.logs[i].After(cutoff)
+	})
+	s.logs = append(s.logs[:0], s.logs[idx:]...)
+
+	timeToAct := now
+	if len(s.logs) >= s.limit {
+		// 要等到倒数第 limit 个请求滑出窗口
+		timeToAct = s.logs[len(s.logs)-s.limit].Add(s.window)
+	}
+	if timeToAct.Sub(now) > maxDelay {
+		return tooLate(timeToAct)
+	}
+	s.logs = append(s.logs, timeToAct)
+	return &Reservation{
+		ok:        true,
+		timeToAct: timeToAct,
+		cancel: func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			for i := len(s.logs) - 1; i >= 0; i-- {
+				if s.logs[i].Equal(timeToAct) {
+					s.logs = append(s.logs[:i], s.logs[i+1:]...)
+					return
+				}
+			}
+		},
+	}
+}
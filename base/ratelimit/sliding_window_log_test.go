@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewSlidingWindowLog(t *testing.T) {
+	_, err := NewSlidingWindowLog(0, time.Second)
+	assert.Equal(t, common.NewErrInvalidArgument("limit", 0), err)
+	_, err = NewSlidingWindowLog(1, 0)
+	assert.Equal(t, common.NewErrInvalidIntervalValue(0), err)
+}
+
+func TestSlidingWindowLog_Reserve(t *testing.T) {
+	s, err := NewSlidingWindowLog(2, time.Second)
+	require.NoError(t, err)
+	now := testNow()
+
+	assert.True(t, s.reserve(now, 0).OK())
+	assert.True(t, s.reserve(now.Add(time.Millisecond*100), 0).OK())
+	// 要等到第一个请求滑出窗口
+	r := s.reserve(now.Add(time.Millisecond*200), 0)
+	assert.False(t, r.OK())
+	assert.Equal(t, now.Add(time.Second), r.timeToAct)
+	r = s.reserve(now.Add(time.Millisecond*200), infDuration)
+	require.True(t, r.OK())
+	assert.Equal(t, now.Add(time.Second), r.timeToAct)
+
+	// 任意一秒里面都不超过 2 个请求
+	r2 := s.reserve(now.Add(time.Second), infDuration)
+	require.True(t, r2.OK())
+	assert.Equal(t, now.Add(time.Millisecond*1100), r2.timeToAct)
+
+	// 取消之后配额还回来
+	r2.Cancel()
+	r.Cancel()
+	assert.True(t, s.reserve(now.Add(time.Second), 0).OK())
+
+	// 窗口滑过去之后之前的记录被清理
+	now = now.Add(time.Hour)
+	assert.True(t, s.reserve(now, 0).OK())
+	assert.True(t, s.reserve(now, 0).OK())
+	assert.Len(t, s.logs, 2)
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶
+// 以 rate 个每秒的速度往桶里面放令牌，桶最多放 burst 个令牌，每个请求拿走一个令牌。
+// 允许 burst 大小的突发流量
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	// last tokens 最后一次更新的时间
+	last time.Time
+}
+
+// NewTokenBucket 创建令牌桶，rate 是每秒产生的令牌数量，桶一开始是满的
+func NewTokenBucket(rate float64, burst int) (*TokenBucket, error) {
+	if rate <= 0 {
+		return nil, common.NewErrInvalidArgument("rate", rate)
+	}
+	if burst <= 0 {
+		return nil, common.NewErrInvalidArgument("burst", burst)
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+func (t *TokenBucket) Allow() bool {
+	return allow(t)
+}
+
+func (t *TokenBucket) Wait(ctx context.Context) error {
+	return wait(ctx, t)
+}
+
+func (t *TokenBucket) Reserve() *Reservation {
+	return reserve(t)
+}
+
+func (t *TokenBucket) reserve(now time.Time, maxDelay time.Duration) *Reservation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tokens := t.advance(now) - 1
+	var delay time.Duration
+	if tokens < 0 {
+		// 令牌不够，欠下的令牌要等一段时间才能补上
+		delay = time.Duration(-tokens / t.rate * float64(time.Second))
+	}
+	timeToAct := now.Add(delay)
+	if delay > maxDelay {
+		return tooLate(timeToAct)
+	}
+	t.tokens = tokens
+	t.touch(now)
+	return &Reservation{
+		ok:        true,
+		timeToAct: timeToAct,
+		cancel: func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			now := time.Now()
+			t.tokens = t.advance(now) + 1
+			if t.tokens > t.burst {
+				t.tokens = t.burst
+			}
+			t.touch(now)
+		},
+	}
+}
+
+// advance 计算 now 的时候桶里面有多少令牌
+func (t *TokenBucket) advance(now time.Time) float64 {
+	elapsed := now.Sub(t.last)
+	if elapsed <= 0 {
+		return t.tokens
+	}
+	tokens := t.tokens + elapsed.Seconds()*t.rate
+	if tokens > t.burst {
+		tokens = t.burst
+	}
+	return tokens
+}
+
+// touch 更新 last，并发的时候 now 可能比 last 还早，这时候不能回退
+func (t *TokenBucket) touch(now time.Time) {
+	if now.After(t.last) {
+		t.last = now
+	}
+}
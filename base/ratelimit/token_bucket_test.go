@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// testNow 测试里面的起始时间，放在将来，这样预定的请求还没到执行时间，可以被 Cancel
+func testNow() time.Time {
+	return time.Now().Add(time.Hour).Truncate(time.Second)
+}
+
+func TestNewTokenBucket(t *testing.T) {
+	_, err := NewTokenBucket(0, 1)
+	assert.Equal(t, common.NewErrInvalidArgument("rate", float64(0)), err)
+	_, err = NewTokenBucket(1, 0)
+	assert.Equal(t, common.NewErrInvalidArgument("burst", 0), err)
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	tb, err := NewTokenBucket(10, 2)
+	require.NoError(t, err)
+	now := testNow()
+	tb.last = now
+
+	// 一开始桶是满的，允许 burst 个突发请求
+	assert.True(t, tb.reserve(now, 0).OK())
+	assert.True(t, tb.reserve(now, 0).OK())
+	r := tb.reserve(now, 0)
+	assert.False(t, r.OK())
+	// 不愿意等的请求不占用令牌
+	assert.Equal(t, now.Add(time.Millisecond*100), r.timeToAct)
+
+	// 100ms 产生一个令牌
+	assert.True(t, tb.reserve(now.Add(time.Millisecond*100), 0).OK())
+	r = tb.reserve(now.Add(time.Millisecond*100), infDuration)
+	require.True(t, r.OK())
+	assert.Equal(t, time.Millisecond*100, r.DelayFrom(now.Add(time.Millisecond*100)))
+
+	// 取消之后令牌还回来
+	r.Cancel()
+	r = tb.reserve(now.Add(time.Millisecond*100), infDuration)
+	require.True(t, r.OK())
+	assert.Equal(t, time.Millisecond*100, r.DelayFrom(now.Add(time.Millisecond*100)))
+
+	// 令牌不会超过 burst
+	now = now.Add(time.Hour)
+	assert.True(t, tb.reserve(now, 0).OK())
+	assert.True(t, tb.reserve(now, 0).OK())
+	assert.False(t, tb.reserve(now, 0).OK())
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrLimitExceeded 请求被拒绝，比如漏桶已经满了
+	ErrLimitExceeded = errors.New("ratelimit: 请求过多")
+	// ErrExceedsDeadline 需要等待的时间超过了 ctx 的 deadline
+	ErrExceedsDeadline = errors.New("ratelimit: 需要等待的时间超过了 ctx 的 deadline")
+)
+
+// Limiter 限流器，实现都是并发安全的
+type Limiter interface {
+	// Allow 现在能不能通过一个请求，不能通过的时候不会占用配额
+	Allow() bool
+	// Wait 等到能通过一个请求为止
+	// ctx 结束的时候返回 ctx 的错误；需要等待的时间超过 ctx 的 deadline 的时候直接返回 ErrExceedsDeadline
+	Wait(ctx context.Context) error
+	// Reserve 预定一个请求，调用方自己按照 Reservation.Delay 等待之后再执行
+	Reserve() *Reservation
+}
+
+// Reservation 预定的结果
+type Reservation struct {
+	ok        bool
+	timeToAct time.Time
+	cancel    func()
+}
+
+// OK 预定是否成功，失败的时候不需要 Cancel
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 还需要等待多久才能执行
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom 从 now 开始还需要等待多久才能执行
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+	delay := r.timeToAct.Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel 不再需要这个预定，归还占用的配额
+// 已经到了执行时间的预定不会被归还
+func (r *Reservation) Cancel() {
+	if !r.ok || r.cancel == nil || !time.Now().Before(r.timeToAct) {
+		return
+	}
+	r.cancel()
+	r.cancel = nil
+}
+
+// reserver 各个限流器的核心逻辑
+// maxDelay 是最多愿意等待的时间，需要等待的时间超过 maxDelay 的时候不会占用配额，并且返回失败的 Reservation
+type reserver interface {
+	reserve(now time.Time, maxDelay time.Duration) *Reservation
+}
+
+// infDuration 不限制等待的时间
+const infDuration = time.Duration(1<<63 - 1)
+
+func allow(r reserver) bool {
+	return r.reserve(time.Now(), 0).OK()
+}
+
+func reserve(r reserver) *Reservation {
+	return r.reserve(time.Now(), infDuration)
+}
+
+func wait(ctx context.Context, r reserver) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	maxDelay := infDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxDelay = deadline.Sub(now)
+	}
+	res := r.reserve(now, maxDelay)
+	if !res.OK() {
+		// 失败的预定带着执行时间，说明是因为要等太久
+		if !res.timeToAct.IsZero() {
+			return ErrExceedsDeadline
+		}
+		return ErrLimitExceeded
+	}
+	delay := res.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		res.Cancel()
+		return ctx.Err()
+	}
+}
+
+// rejected 因为限流器拒绝而失败的预定
+func rejected() *Reservation {
+	return &Reservation{}
+}
+
+// tooLate 因为需要等待的时间超过 maxDelay 而失败的预定
+func tooLate(timeToAct time.Time) *Reservation {
+	return &Reservation{timeToAct: timeToAct}
+}
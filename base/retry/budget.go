@@ -0,0 +1,194 @@
+package retry
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/colin-water/go_tool_libaray/base/internal/window"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Budget 重试预算，限制重试给下游带来的额外压力
+// 下游故障的时候，每个调用都重试好几次，会让下游的压力成倍增加，
+// 所以需要在多个调用之间共享一个预算，预算用完了就不再重试。实现都必须是并发安全的
+type Budget interface {
+	// Request 开始一次新的调用（不包括重试）
+	Request()
+	// Failure 一次尝试失败了
+	Failure()
+	// Withdraw 申请一次重试，返回 false 表示预算已经用完
+	Withdraw() bool
+}
+
+// WithBudget 给重试策略加上预算的限制，s 允许重试并且预算足够的时候才会重试
+// s 是 Policy 的时候返回的策略也是 Policy，Do 和 redis_lock 每次调用都会通过 Start 记录一次新的调用，
+// 所以同一个策略可以直接在多个 goroutine 之间共享。
+// s 不是 Policy 的时候，重试状态只有一份，返回的策略只能用于一次调用，创建的时候就记录这次调用
+func WithBudget(s Strategy, budget Budget) Strategy {
+	b := &budgetStrategy{s: s, budget: budget}
+	if _, ok := s.(Policy); ok {
+		return budgetPolicy{b}
+	}
+	budget.Request()
+	return b
+}
+
+type budgetStrategy struct {
+	s      Strategy
+	budget Budget
+}
+
+type budgetPolicy struct {
+	*budgetStrategy
+}
+
+func (p budgetPolicy) Start() Backoff {
+	return p.StartContext(context.Background())
+}
+
+func (p budgetPolicy) StartContext(ctx context.Context) Backoff {
+	p.budget.Request()
+	return &budgetStrategy{s: StartContext(ctx, p.s), budget: p.budget}
+}
+
+func (b *budgetStrategy) Next() (time.Duration, bool) {
+	b.budget.Failure()
+	interval, ok := b.s.Next()
+	if !ok || !b.budget.Withdraw() {
+		return 0, false
+	}
+	return interval, true
+}
+
+func (b *budgetStrategy) Reset() {
+	if r, ok := b.s.(Backoff); ok {
+		r.Reset()
+	}
+}
+
+// TokenBucketBudget 基于令牌桶的重试预算
+// 每一次调用往桶里面放 ratio 个令牌，每一次重试从桶里面拿走一个令牌，
+// 所以长期来看重试的次数不会超过调用次数的 ratio 倍，比如 ratio 是 0.1 的时候重试最多带来 10% 的额外压力。
+// 桶一开始是满的，调用量很小的时候也能有 maxTokens 次重试
+type TokenBucketBudget struct {
+	mutex     sync.Mutex
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+}
+
+// NewTokenBucketBudget 创建基于令牌桶的重试预算
+func NewTokenBucketBudget(ratio float64, maxTokens float64) (*TokenBucketBudget, error) {
+	if ratio <= 0 {
+		return nil, common.NewErrInvalidArgument("ratio", ratio)
+	}
+	if maxTokens < 1 {
+		return nil, common.NewErrInvalidArgument("maxTokens", maxTokens)
+	}
+	return &TokenBucketBudget{
+		ratio:     ratio,
+		maxTokens: maxTokens,
+		tokens:    maxTokens,
+	}, nil
+}
+
+func (b *TokenBucketBudget) Request() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+func (b *TokenBucketBudget) Failure() {}
+
+func (b *TokenBucketBudget) Withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Tokens 返回桶里面剩余的令牌数量
+func (b *TokenBucketBudget) Tokens() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.tokens
+}
+
+// AdaptiveBudget 自适应的重试预算
+// 参考 Google SRE 的客户端节流：统计最近一个窗口里面尝试的次数 requests 和成功的次数 accepts，
+// 以 max(0, (requests - k * accepts) / (requests + 1)) 的概率拒绝重试。
+// 下游正常的时候几乎不会拒绝，失败的比例越高拒绝得越多；k 越小越激进，一般取 2
+type AdaptiveBudget struct {
+	mutex  sync.Mutex
+	k      float64
+	window *window.Window
+}
+
+// NewAdaptiveBudget 创建自适应的重试预算，size 是统计的窗口大小
+func NewAdaptiveBudget(k float64, size time.Duration) (*AdaptiveBudget, error) {
+	if k < 1 {
+		return nil, common.NewErrInvalidArgument("k", k)
+	}
+	w, err := window.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &AdaptiveBudget{
+		k:      k,
+		window: w,
+	}, nil
+}
+
+func (b *AdaptiveBudget) Request() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.window.Add(time.Now(), 1, 0)
+}
+
+func (b *AdaptiveBudget) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.window.Add(time.Now(), 0, 1)
+}
+
+func (b *AdaptiveBudget) Withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if rand.Float64() < b.rejectProbability() {
+		return false
+	}
+	// 重试本身也是一次尝试
+	b.window.Add(time.Now(), 1, 0)
+	return true
+}
+
+// RejectProbability 返回当前拒绝重试的概率
+func (b *AdaptiveBudget) RejectProbability() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rejectProbability()
+}
+
+func (b *AdaptiveBudget) rejectProbability() float64 {
+	requests, failures := b.window.Sum(time.Now())
+	accepts := float64(requests - failures)
+	p := (float64(requests) - b.k*accepts) / float64(requests+1)
+	if p < 0 {
+		return 0
+	}
+	return p
+}
+
+var (
+	_ Budget = &TokenBucketBudget{}
+	_ Budget = &AdaptiveBudget{}
+
+	_ ContextPolicy = budgetPolicy{}
+)
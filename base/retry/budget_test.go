@@ -0,0 +1,149 @@
+package retry
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/colin-water/go_tool_libaray/base/internal/window"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewTokenBucketBudget(t *testing.T) {
+	_, err := NewTokenBucketBudget(0, 10)
+	assert.Equal(t, common.NewErrInvalidArgument("ratio", float64(0)), err)
+	_, err = NewTokenBucketBudget(0.1, 0.5)
+	assert.Equal(t, common.NewErrInvalidArgument("maxTokens", 0.5), err)
+	b, err := NewTokenBucketBudget(0.1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10), b.Tokens())
+}
+
+func TestTokenBucketBudget(t *testing.T) {
+	b, err := NewTokenBucketBudget(0.5, 2)
+	require.NoError(t, err)
+	// 一开始桶是满的
+	assert.True(t, b.Withdraw())
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+	// 两次调用攒够一次重试
+	b.Request()
+	assert.False(t, b.Withdraw())
+	b.Request()
+	assert.True(t, b.Withdraw())
+	// 不会超过 maxTokens
+	for i := 0; i < 10; i++ {
+		b.Request()
+	}
+	assert.Equal(t, float64(2), b.Tokens())
+}
+
+func TestNewAdaptiveBudget(t *testing.T) {
+	testCases := []struct {
+		name    string
+		k       float64
+		size    time.Duration
+		wantErr error
+	}{
+		{name: "k less than 1", k: 0.5, size: time.Second, wantErr: common.NewErrInvalidArgument("k", 0.5)},
+		{name: "window too small", k: 2, size: window.Buckets - 1,
+			wantErr: common.NewErrInvalidArgument("window", time.Duration(window.Buckets-1))},
+		{name: "ok", k: 2, size: time.Second},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewAdaptiveBudget(tc.k, tc.size)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestAdaptiveBudget(t *testing.T) {
+	b, err := NewAdaptiveBudget(2, time.Millisecond*100)
+	require.NoError(t, err)
+	// 全部成功的时候不会拒绝
+	for i := 0; i < 100; i++ {
+		b.Request()
+	}
+	assert.Equal(t, float64(0), b.RejectProbability())
+
+	// 全部失败的时候几乎全部拒绝
+	b, err = NewAdaptiveBudget(2, time.Millisecond*100)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		b.Request()
+		b.Failure()
+	}
+	assert.InDelta(t, 100.0/101, b.RejectProbability(), 0.001)
+	// 窗口过去之后恢复
+	time.Sleep(time.Millisecond * 150)
+	assert.Equal(t, float64(0), b.RejectProbability())
+	assert.True(t, b.Withdraw())
+}
+
+func TestBudget_Concurrent(t *testing.T) {
+	tokenBucket, err := NewTokenBucketBudget(0.1, 10)
+	require.NoError(t, err)
+	adaptive, err := NewAdaptiveBudget(2, time.Second)
+	require.NoError(t, err)
+	for name, b := range map[string]Budget{"token bucket": tokenBucket, "adaptive": adaptive} {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			s := WithBudget(newFixedForTest(t, time.Millisecond, 3), b)
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						drain(Start(s))
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+	// 1000 次调用一共攒了 100 个令牌，加上一开始的 10 个
+	assert.Less(t, tokenBucket.Tokens(), float64(1))
+}
+
+func TestWithBudget(t *testing.T) {
+	b, err := NewTokenBucketBudget(1, 100)
+	require.NoError(t, err)
+
+	// 包装 Policy 的时候每次 Start 都有自己的重试次数
+	s := WithBudget(newFixedForTest(t, time.Millisecond, 2), b)
+	_, ok := s.(Policy)
+	require.True(t, ok)
+	assert.Equal(t, 2, drain(Start(s)))
+	assert.Equal(t, 2, drain(Start(s)))
+
+	// 不是 Policy 的时候不能假装每次调用都是独立的
+	s = WithBudget(&onceStrategy{}, b)
+	_, ok = s.(Policy)
+	assert.False(t, ok)
+	assert.Same(t, s, Start(s))
+	assert.Equal(t, 1, drain(s))
+
+	// 预算用完了就不再重试
+	b, err = NewTokenBucketBudget(0.1, 1)
+	require.NoError(t, err)
+	s = WithBudget(newFixedForTest(t, time.Millisecond, 5), b)
+	assert.Equal(t, 1, drain(Start(s)))
+	assert.Equal(t, 0, drain(Start(s)))
+}
+
+// onceStrategy 只允许重试一次，不是 Policy
+type onceStrategy struct {
+	done bool
+}
+
+func (s *onceStrategy) Next() (time.Duration, bool) {
+	if s.done {
+		return 0, false
+	}
+	s.done = true
+	return time.Millisecond, true
+}
@@ -0,0 +1,190 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PermanentError 不可重试的错误，Do 遇到之后会马上返回
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent 把 err 标记为不可重试，err 为 nil 的时候返回 nil
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// maxErrors Error 里面最多保留多少个错误
+// 无限重试的时候一直等下去会攒下很多一样的错误，所以只保留第一个和最后 maxErrors-1 个
+const maxErrors = 10
+
+// Error Do 最终失败的时候返回的错误
+// Err 是保留下来的错误通过 errors.Join 合并之后的结果，
+// 所以可以直接用 errors.Is 和 errors.As 判断第一次和最近几次的错误
+type Error struct {
+	// Attempts 一共尝试了多少次
+	Attempts int
+	// Dropped 中间被丢掉的错误的数量
+	Dropped int
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Dropped > 0 {
+		return fmt.Sprintf("retry: 尝试了 %d 次之后失败，省略了中间 %d 个错误: %v", e.Attempts, e.Dropped, e.Err)
+	}
+	return fmt.Sprintf("retry: 尝试了 %d 次之后失败: %v", e.Attempts, e.Err)
+}
+
+// errorList 收集每一次尝试的错误，超过 maxErrors 个的时候丢掉第一个之后最早的那个
+type errorList struct {
+	errs    []error
+	dropped int
+}
+
+func (l *errorList) add(err error) {
+	if len(l.errs) < maxErrors {
+		l.errs = append(l.errs, err)
+		return
+	}
+	copy(l.errs[1:], l.errs[2:])
+	l.errs[len(l.errs)-1] = err
+	l.dropped++
+}
+
+func (l *errorList) error(attempts int) *Error {
+	return &Error{Attempts: attempts, Dropped: l.dropped, Err: errors.Join(l.errs...)}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type options struct {
+	retryable func(err error) bool
+	notify    func(attempt int, err error, next time.Duration)
+	wakeup    <-chan struct{}
+}
+
+// Option Do 的可选配置
+type Option func(o *options)
+
+// WithRetryable 判断错误是否可以重试，返回 false 的时候 Do 会马上返回
+// 默认除了 Permanent 包装过的错误，其它错误都可以重试，fn 为 nil 的时候也使用默认的判断
+func WithRetryable(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.retryable = fn
+	}
+}
+
+// WithNotify 每一次尝试失败并且准备重试的时候回调，
+// attempt 是已经尝试的次数，next 是距离下一次重试的间隔
+func WithNotify(fn func(attempt int, err error, next time.Duration)) Option {
+	return func(o *options) {
+		o.notify = fn
+	}
+}
+
+// WithWakeup 等待重试的时候 wakeup 收到消息就马上进行下一次尝试，不用等到重试间隔结束
+// 比如分布式锁订阅了锁的释放通知，锁一释放就可以马上去抢
+func WithWakeup(wakeup <-chan struct{}) Option {
+	return func(o *options) {
+		o.wakeup = wakeup
+	}
+}
+
+// Do 执行 fn，失败的时候按照 s 进行重试，直到成功、s 不允许继续重试、错误不可重试或者 ctx 结束
+// s 是 Policy 的时候每次调用都会通过 StartContext 创建新的重试状态。失败的时候返回 *Error
+func Do(ctx context.Context, s Strategy, fn func(ctx context.Context) error, opts ...Option) error {
+	_, err := DoValue(ctx, s, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	}, opts...)
+	return err
+}
+
+// DoValue 和 Do 一样，只是 fn 会返回一个值
+// 失败的时候返回最后一次尝试得到的值，一次都没有尝试的时候返回零值
+func DoValue[T any](ctx context.Context, s Strategy, fn func(ctx context.Context) (T, error), opts ...Option) (T, error) {
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.retryable == nil {
+		opt.retryable = func(err error) bool { return true }
+	}
+	s = StartContext(ctx, s)
+
+	var (
+		res   T
+		err   error
+		errs  errorList
+		timer *time.Timer
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			errs.add(err)
+			return res, errs.error(attempt - 1)
+		}
+		res, err = fn(ctx)
+		if err == nil {
+			return res, nil
+		}
+
+		var pe *PermanentError
+		if errors.As(err, &pe) {
+			errs.add(pe.Err)
+			return res, errs.error(attempt)
+		}
+		errs.add(err)
+		if !opt.retryable(err) {
+			return res, errs.error(attempt)
+		}
+
+		interval, ok := s.Next()
+		if !ok {
+			return res, errs.error(attempt)
+		}
+		if opt.notify != nil {
+			opt.notify(attempt, err, interval)
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(interval)
+		} else {
+			timer.Reset(interval)
+		}
+		select {
+		case <-timer.C:
+		case <-opt.wakeup:
+			// 被提前唤醒，停掉定时器马上重试
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			errs.add(ctx.Err())
+			return res, errs.error(attempt)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var (
+	errTemporary = errors.New("temporary")
+	errFatal     = errors.New("fatal")
+)
+
+func newFixedForTest(t *testing.T, interval time.Duration, maxRetries int32) *FixedIntervalRetryStrategy {
+	s, err := NewFixedIntervalRetryStrategy(interval, maxRetries)
+	require.NoError(t, err)
+	return s
+}
+
+func TestDo_Classify(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 每一次尝试返回的错误
+		errs         []error
+		opts         []Option
+		wantAttempts int
+		wantErrs     []error
+	}{
+		{
+			name:         "success",
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry until success",
+			errs:         []error{errTemporary, errTemporary, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "exhausted",
+			errs:         []error{errTemporary, errTemporary, errTemporary, errTemporary},
+			wantAttempts: 4,
+			wantErrs:     []error{errTemporary},
+		},
+		{
+			name:         "permanent",
+			errs:         []error{errTemporary, Permanent(errFatal), nil},
+			wantAttempts: 2,
+			wantErrs:     []error{errTemporary, errFatal},
+		},
+		{
+			name: "not retryable",
+			errs: []error{errTemporary, errFatal, nil},
+			opts: []Option{WithRetryable(func(err error) bool {
+				return !errors.Is(err, errFatal)
+			})},
+			wantAttempts: 2,
+			wantErrs:     []error{errTemporary, errFatal},
+		},
+		{
+			name:         "nil retryable",
+			errs:         []error{errTemporary, nil},
+			opts:         []Option{WithRetryable(nil)},
+			wantAttempts: 2,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), newFixedForTest(t, time.Millisecond, 3), func(ctx context.Context) error {
+				err := tc.errs[attempts]
+				attempts++
+				return err
+			}, tc.opts...)
+			assert.Equal(t, tc.wantAttempts, attempts)
+			if tc.wantErrs == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var retryErr *Error
+			require.ErrorAs(t, err, &retryErr)
+			assert.Equal(t, tc.wantAttempts, retryErr.Attempts)
+			for _, want := range tc.wantErrs {
+				assert.ErrorIs(t, err, want)
+			}
+			// Permanent 只是标记，不会出现在最终的错误里面
+			var pe *PermanentError
+			assert.False(t, errors.As(err, &pe))
+		})
+	}
+}
+
+func TestDo_Notify(t *testing.T) {
+	var attempts []int
+	var intervals []time.Duration
+	_ = Do(context.Background(), newFixedForTest(t, time.Millisecond, 2), func(ctx context.Context) error {
+		return errTemporary
+	}, WithNotify(func(attempt int, err error, next time.Duration) {
+		assert.Equal(t, errTemporary, err)
+		attempts = append(attempts, attempt)
+		intervals = append(intervals, next)
+	}))
+	// 最后一次失败之后不会再重试，也就不会回调
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Millisecond}, intervals)
+}
+
+func TestDo_ContextCancel(t *testing.T) {
+	// 等待重试的时候 ctx 被取消，马上返回
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		cancel()
+	}()
+	start := time.Now()
+	attempts := 0
+	err := Do(ctx, newFixedForTest(t, time.Hour, 0), func(ctx context.Context) error {
+		attempts++
+		return errTemporary
+	})
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 1, attempts)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errTemporary)
+	var retryErr *Error
+	require.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 1, retryErr.Attempts)
+
+	// ctx 已经结束了，一次都不会尝试
+	attempts = 0
+	err = Do(ctx, newFixedForTest(t, time.Millisecond, 0), func(ctx context.Context) error {
+		attempts++
+		return nil
+	})
+	assert.Equal(t, 0, attempts)
+	assert.ErrorIs(t, err, context.Canceled)
+	require.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 0, retryErr.Attempts)
+}
+
+func TestDo_Wakeup(t *testing.T) {
+	wakeup := make(chan struct{}, 1)
+	start := time.Now()
+	attempts := 0
+	err := Do(context.Background(), newFixedForTest(t, time.Hour, 0), func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			wakeup <- struct{}{}
+			return errTemporary
+		}
+		return nil
+	}, WithWakeup(wakeup))
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	// 被唤醒之后不用等重试间隔
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDoValue(t *testing.T) {
+	attempts := 0
+	res, err := DoValue(context.Background(), newFixedForTest(t, time.Millisecond, 3), func(ctx context.Context) (int, error) {
+		attempts++
+		if attempts < 3 {
+			return attempts, errTemporary
+		}
+		return attempts, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, res)
+
+	// 失败的时候返回最后一次尝试的值
+	attempts = 0
+	res, err = DoValue(context.Background(), newFixedForTest(t, time.Millisecond, 2), func(ctx context.Context) (int, error) {
+		attempts++
+		return attempts * 10, errTemporary
+	})
+	assert.ErrorIs(t, err, errTemporary)
+	assert.Equal(t, 30, res)
+}
+
+func TestDo_ErrorLimit(t *testing.T) {
+	errs := make([]error, 25)
+	for i := range errs {
+		errs[i] = fmt.Errorf("第 %d 次失败", i+1)
+	}
+	attempts := 0
+	err := Do(context.Background(), newFixedForTest(t, time.Microsecond, int32(len(errs)-1)), func(ctx context.Context) error {
+		attempts++
+		return errs[attempts-1]
+	})
+	var retryErr *Error
+	require.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 25, retryErr.Attempts)
+	// 只保留第一个和最后 maxErrors-1 个错误
+	assert.Equal(t, 25-maxErrors, retryErr.Dropped)
+	assert.ErrorIs(t, err, errs[0])
+	assert.NotErrorIs(t, err, errs[1])
+	assert.NotErrorIs(t, err, errs[25-maxErrors])
+	for _, e := range errs[25-maxErrors+1:] {
+		assert.ErrorIs(t, err, e)
+	}
+	assert.Contains(t, err.Error(), "省略了中间 15 个错误")
+}
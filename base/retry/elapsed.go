@@ -0,0 +1,106 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// MaxElapsed 限制重试的总耗时，超过 maxElapsed 之后不再重试
+// 下一次重试的间隔加上预估的尝试耗时会超过期限的时候，会缩短最后一次的间隔，
+// 让最后一次尝试刚好能在期限之前完成；连一次尝试的时间都不够的时候直接放弃。
+// 尝试的耗时用上一次尝试的实际耗时来预估。maxElapsed 小于等于 0 的时候只参考 ctx 的 deadline。
+// 返回的策略是 ContextPolicy，Do 每次调用都会通过 StartContext 重新开始计时，
+// 并且不会超过这次调用的 ctx 的 deadline，两个期限里面以先到的那个为准。
+// 不经过 Start 直接使用的时候，从第一次调用 Next 开始计时
+func MaxElapsed(s Strategy, maxElapsed time.Duration) Strategy {
+	return &elapsedStrategy{
+		s:          s,
+		ctx:        context.Background(),
+		maxElapsed: maxElapsed,
+	}
+}
+
+// minAttemptEstimate 预估的尝试耗时的下限，
+// 避免尝试很快的时候把最后一次重试安排在刚好到期的那一刻
+const minAttemptEstimate = time.Millisecond
+
+type elapsedStrategy struct {
+	s          Strategy
+	ctx        context.Context
+	maxElapsed time.Duration
+
+	started     bool
+	deadline    time.Time
+	hasDeadline bool
+	// attemptStart 当前这次尝试开始的时间
+	attemptStart time.Time
+}
+
+// begin 开始计时，now 是第一次尝试开始的时间
+func (e *elapsedStrategy) begin(now time.Time) {
+	e.started = true
+	e.attemptStart = now
+	e.hasDeadline = false
+	if e.maxElapsed > 0 {
+		e.deadline = now.Add(e.maxElapsed)
+		e.hasDeadline = true
+	}
+	if d, ok := e.ctx.Deadline(); ok && (!e.hasDeadline || d.Before(e.deadline)) {
+		e.deadline = d
+		e.hasDeadline = true
+	}
+}
+
+func (e *elapsedStrategy) Next() (time.Duration, bool) {
+	interval, ok := e.s.Next()
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+	if !e.started {
+		// 没有经过 Start，不知道第一次尝试是什么时候开始的，从现在开始计时
+		e.begin(now)
+	}
+	if !e.hasDeadline {
+		return interval, true
+	}
+	// 用刚刚结束的这次尝试的耗时来预估下一次尝试的耗时
+	estimate := now.Sub(e.attemptStart)
+	if estimate < minAttemptEstimate {
+		estimate = minAttemptEstimate
+	}
+	remaining := e.deadline.Sub(now) - estimate
+	if remaining <= 0 {
+		return 0, false
+	}
+	if interval > remaining {
+		interval = remaining
+	}
+	e.attemptStart = now.Add(interval)
+	return interval, true
+}
+
+func (e *elapsedStrategy) Start() Backoff {
+	return e.StartContext(context.Background())
+}
+
+// StartContext 创建新的重试状态，从现在开始计时，并且不会超过 ctx 的 deadline
+func (e *elapsedStrategy) StartContext(ctx context.Context) Backoff {
+	res := &elapsedStrategy{
+		s:          StartContext(ctx, e.s),
+		ctx:        ctx,
+		maxElapsed: e.maxElapsed,
+	}
+	res.begin(time.Now())
+	return res
+}
+
+// Reset 重置重试状态，从下一次调用 Next 开始重新计时
+func (e *elapsedStrategy) Reset() {
+	if r, ok := e.s.(Backoff); ok {
+		r.Reset()
+	}
+	e.started = false
+}
+
+var _ ContextPolicy = &elapsedStrategy{}
@@ -0,0 +1,93 @@
+package retry
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMaxElapsed_StartLazily(t *testing.T) {
+	s := MaxElapsed(newFixedForTest(t, time.Millisecond, 0), time.Millisecond*50)
+	// 创建之后过了很久才开始用，不会一开始就超时
+	time.Sleep(time.Millisecond * 60)
+	_, ok := Start(s).Next()
+	assert.True(t, ok)
+	_, ok = s.Next()
+	assert.True(t, ok)
+}
+
+func TestMaxElapsed_Truncate(t *testing.T) {
+	b := Start(MaxElapsed(newFixedForTest(t, time.Hour, 0), time.Millisecond*50))
+	// 最后一次的间隔被缩短，让最后一次尝试在期限之前完成
+	interval, ok := b.Next()
+	require.True(t, ok)
+	assert.Less(t, interval, time.Millisecond*50)
+	time.Sleep(interval)
+	_, ok = b.Next()
+	assert.False(t, ok)
+}
+
+func TestMaxElapsed_Do(t *testing.T) {
+	s := MaxElapsed(newFixedForTest(t, time.Millisecond*10, 0), time.Millisecond*100)
+	// 同一个策略每次调用都重新计时
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		attempts := 0
+		err := Do(context.Background(), s, func(ctx context.Context) error {
+			attempts++
+			return errTemporary
+		})
+		assert.ErrorIs(t, err, errTemporary)
+		assert.Less(t, time.Since(start), time.Millisecond*150)
+		assert.Greater(t, attempts, 3)
+	}
+}
+
+func TestMaxElapsed_ContextDeadline(t *testing.T) {
+	s := MaxElapsed(newFixedForTest(t, time.Hour, 3), 0)
+
+	// 使用的是每次调用的 ctx 的 deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	attempts := 0
+	err := Do(ctx, s, func(ctx context.Context) error {
+		attempts++
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, errTemporary)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Millisecond*100)
+	assert.Equal(t, 2, attempts)
+
+	// 没有 deadline 的调用不受上一次调用的影响
+	b := StartContext(context.Background(), s)
+	interval, ok := b.Next()
+	require.True(t, ok)
+	assert.Equal(t, time.Hour, interval)
+}
+
+func TestMaxElapsed_Reset(t *testing.T) {
+	b := Start(MaxElapsed(newFixedForTest(t, time.Millisecond, 0), time.Millisecond*20))
+	time.Sleep(time.Millisecond * 30)
+	_, ok := b.Next()
+	assert.False(t, ok)
+	// 重置之后重新计时
+	b.(Backoff).Reset()
+	_, ok = b.Next()
+	assert.True(t, ok)
+}
+
+func TestWithBudget_StartContext(t *testing.T) {
+	budget, err := NewTokenBucketBudget(1, 10)
+	require.NoError(t, err)
+	// 预算包在外面的时候 ctx 也能传到 MaxElapsed
+	s := WithBudget(MaxElapsed(newFixedForTest(t, time.Hour, 0), 0), budget)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	interval, ok := StartContext(ctx, s).Next()
+	require.True(t, ok)
+	assert.Less(t, interval, time.Millisecond*50)
+}
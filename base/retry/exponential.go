@@ -57,3 +57,18 @@ func (s *ExponentialBackoffRetryStrategy) Next() (time.Duration, bool) {
 
 	return interval, true
 }
+
+// Start 创建一个新的重试状态，重试次数从 0 开始
+func (s *ExponentialBackoffRetryStrategy) Start() Backoff {
+	return &ExponentialBackoffRetryStrategy{
+		initialInterval: s.initialInterval,
+		maxInterval:     s.maxInterval,
+		maxRetries:      s.maxRetries,
+	}
+}
+
+// Reset 重置重试次数
+func (s *ExponentialBackoffRetryStrategy) Reset() {
+	atomic.StoreInt32(&s.retries, 0)
+	s.maxIntervalReached.Store(false)
+}
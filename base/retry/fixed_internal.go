@@ -24,7 +24,7 @@ func NewFixedIntervalRetryStrategy(interval time.Duration, maxRetries int32) (*F
 	}, nil
 }
 
-func (s FixedIntervalRetryStrategy) Next() (time.Duration, bool) {
+func (s *FixedIntervalRetryStrategy) Next() (time.Duration, bool) {
 	retries := atomic.AddInt32(&s.retries, 1)
 
 	// maxRetries >0 且 当前重试次数达到最大次数
@@ -33,3 +33,16 @@ func (s FixedIntervalRetryStrategy) Next() (time.Duration, bool) {
 	}
 	return s.interval, true
 }
+
+// Start 创建一个新的重试状态，重试次数从 0 开始
+func (s *FixedIntervalRetryStrategy) Start() Backoff {
+	return &FixedIntervalRetryStrategy{
+		maxRetries: s.maxRetries,
+		interval:   s.interval,
+	}
+}
+
+// Reset 重置重试次数
+func (s *FixedIntervalRetryStrategy) Reset() {
+	atomic.StoreInt32(&s.retries, 0)
+}
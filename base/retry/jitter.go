@@ -0,0 +1,194 @@
+package retry
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 带抖动的重试策略
+// 大量实例同时失败的时候，如果大家的重试间隔都一样，就会在同一个时间点一起重试，
+// 把压力又集中到了下游。加上随机抖动之后，重试的时间点会被打散。
+// 参考 https://aws.amazon.com/cn/blogs/architecture/exponential-backoff-and-jitter/
+
+// FullJitterRetryStrategy 完全抖动
+// 重试间隔是 [0, min(maxInterval, initialInterval * 2^n)) 之间的随机值
+type FullJitterRetryStrategy struct {
+	initialInterval time.Duration // 初始重试间隔
+	maxInterval     time.Duration // 最大重试间隔
+	maxRetries      int32         // 最大重试次数，如果是 0 或负数，表示无限重试
+	retries         int32         // 当前重试次数
+}
+
+// NewFullJitterRetryStrategy 创建一个完全抖动的重试策略实例
+func NewFullJitterRetryStrategy(initialInterval, maxInterval time.Duration, maxRetries int32) (*FullJitterRetryStrategy, error) {
+	if err := checkJitterInterval(initialInterval, maxInterval); err != nil {
+		return nil, err
+	}
+	return &FullJitterRetryStrategy{
+		initialInterval: initialInterval,
+		maxInterval:     maxInterval,
+		maxRetries:      maxRetries,
+	}, nil
+}
+
+// Next 计算下一次重试的间隔时间，并返回是否可以继续重试
+func (s *FullJitterRetryStrategy) Next() (time.Duration, bool) {
+	retries := atomic.AddInt32(&s.retries, 1)
+	if s.maxRetries > 0 && retries > s.maxRetries {
+		return 0, false
+	}
+	interval := exponentialInterval(s.initialInterval, s.maxInterval, retries)
+	return randDuration(interval), true
+}
+
+// Start 创建一个新的重试状态，重试次数从 0 开始
+func (s *FullJitterRetryStrategy) Start() Backoff {
+	return &FullJitterRetryStrategy{
+		initialInterval: s.initialInterval,
+		maxInterval:     s.maxInterval,
+		maxRetries:      s.maxRetries,
+	}
+}
+
+// Reset 重置重试次数
+func (s *FullJitterRetryStrategy) Reset() {
+	atomic.StoreInt32(&s.retries, 0)
+}
+
+// EqualJitterRetryStrategy 等抖动
+// 重试间隔是 temp/2 + [0, temp/2) 之间的随机值，其中 temp = min(maxInterval, initialInterval * 2^n)
+// 和完全抖动相比，保证了至少会等待一半的退避时间
+type EqualJitterRetryStrategy struct {
+	initialInterval time.Duration // 初始重试间隔
+	maxInterval     time.Duration // 最大重试间隔
+	maxRetries      int32         // 最大重试次数，如果是 0 或负数，表示无限重试
+	retries         int32         // 当前重试次数
+}
+
+// NewEqualJitterRetryStrategy 创建一个等抖动的重试策略实例
+func NewEqualJitterRetryStrategy(initialInterval, maxInterval time.Duration, maxRetries int32) (*EqualJitterRetryStrategy, error) {
+	if err := checkJitterInterval(initialInterval, maxInterval); err != nil {
+		return nil, err
+	}
+	return &EqualJitterRetryStrategy{
+		initialInterval: initialInterval,
+		maxInterval:     maxInterval,
+		maxRetries:      maxRetries,
+	}, nil
+}
+
+// Next 计算下一次重试的间隔时间，并返回是否可以继续重试
+func (s *EqualJitterRetryStrategy) Next() (time.Duration, bool) {
+	retries := atomic.AddInt32(&s.retries, 1)
+	if s.maxRetries > 0 && retries > s.maxRetries {
+		return 0, false
+	}
+	interval := exponentialInterval(s.initialInterval, s.maxInterval, retries)
+	half := interval / 2
+	return half + randDuration(interval-half), true
+}
+
+// Start 创建一个新的重试状态，重试次数从 0 开始
+func (s *EqualJitterRetryStrategy) Start() Backoff {
+	return &EqualJitterRetryStrategy{
+		initialInterval: s.initialInterval,
+		maxInterval:     s.maxInterval,
+		maxRetries:      s.maxRetries,
+	}
+}
+
+// Reset 重置重试次数
+func (s *EqualJitterRetryStrategy) Reset() {
+	atomic.StoreInt32(&s.retries, 0)
+}
+
+// DecorrelatedJitterRetryStrategy 去相关抖动
+// 重试间隔是 min(maxInterval, [initialInterval, 上一次间隔 * 3) 之间的随机值)
+// 每一次的间隔和上一次的间隔相关，而不是和重试次数相关，打散的效果最好
+type DecorrelatedJitterRetryStrategy struct {
+	initialInterval time.Duration // 初始重试间隔
+	maxInterval     time.Duration // 最大重试间隔
+	maxRetries      int32         // 最大重试次数，如果是 0 或负数，表示无限重试
+	retries         int32         // 当前重试次数
+	prev            atomic.Int64  // 上一次的重试间隔
+}
+
+// NewDecorrelatedJitterRetryStrategy 创建一个去相关抖动的重试策略实例
+func NewDecorrelatedJitterRetryStrategy(initialInterval, maxInterval time.Duration, maxRetries int32) (*DecorrelatedJitterRetryStrategy, error) {
+	if err := checkJitterInterval(initialInterval, maxInterval); err != nil {
+		return nil, err
+	}
+	s := &DecorrelatedJitterRetryStrategy{
+		initialInterval: initialInterval,
+		maxInterval:     maxInterval,
+		maxRetries:      maxRetries,
+	}
+	s.prev.Store(int64(initialInterval))
+	return s, nil
+}
+
+// Next 计算下一次重试的间隔时间，并返回是否可以继续重试
+func (s *DecorrelatedJitterRetryStrategy) Next() (time.Duration, bool) {
+	retries := atomic.AddInt32(&s.retries, 1)
+	if s.maxRetries > 0 && retries > s.maxRetries {
+		return 0, false
+	}
+	prev := time.Duration(s.prev.Load())
+	upper := prev * 3
+	// 防止溢出
+	if upper < prev || upper > s.maxInterval {
+		upper = s.maxInterval
+	}
+	interval := s.initialInterval + randDuration(upper-s.initialInterval)
+	s.prev.Store(int64(interval))
+	return interval, true
+}
+
+// Start 创建一个新的重试状态，重试次数和上一次的间隔都从头开始
+func (s *DecorrelatedJitterRetryStrategy) Start() Backoff {
+	b := &DecorrelatedJitterRetryStrategy{
+		initialInterval: s.initialInterval,
+		maxInterval:     s.maxInterval,
+		maxRetries:      s.maxRetries,
+	}
+	b.prev.Store(int64(s.initialInterval))
+	return b
+}
+
+// Reset 重置重试次数和上一次的间隔
+func (s *DecorrelatedJitterRetryStrategy) Reset() {
+	atomic.StoreInt32(&s.retries, 0)
+	s.prev.Store(int64(s.initialInterval))
+}
+
+func checkJitterInterval(initialInterval, maxInterval time.Duration) error {
+	if initialInterval <= 0 {
+		return common.NewErrInvalidIntervalValue(initialInterval)
+	} else if initialInterval > maxInterval {
+		return common.NewErrInvalidMaxIntervalValue(maxInterval, initialInterval)
+	}
+	return nil
+}
+
+// exponentialInterval 第 retries 次重试的指数退避间隔，不会超过 maxInterval
+func exponentialInterval(initialInterval, maxInterval time.Duration, retries int32) time.Duration {
+	interval := initialInterval
+	for i := int32(1); i < retries; i++ {
+		interval *= 2
+		// 防止溢出或超过最大重试间隔
+		if interval <= 0 || interval > maxInterval {
+			return maxInterval
+		}
+	}
+	return interval
+}
+
+// randDuration 返回 [0, d) 之间的随机值，d 小于等于 0 的时候返回 0
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
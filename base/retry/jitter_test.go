@@ -0,0 +1,133 @@
+package retry
+
+import (
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewJitterRetryStrategy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		initialInterval time.Duration
+		maxInterval     time.Duration
+		wantErr         error
+	}{
+		{
+			name:            "zero initial interval",
+			initialInterval: 0,
+			maxInterval:     time.Second,
+			wantErr:         common.NewErrInvalidIntervalValue(0),
+		},
+		{
+			name:            "max interval less than initial interval",
+			initialInterval: time.Second,
+			maxInterval:     time.Millisecond,
+			wantErr:         common.NewErrInvalidMaxIntervalValue(time.Millisecond, time.Second),
+		},
+		{
+			name:            "ok",
+			initialInterval: time.Millisecond,
+			maxInterval:     time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewFullJitterRetryStrategy(tc.initialInterval, tc.maxInterval, 3)
+			assert.Equal(t, tc.wantErr, err)
+			_, err = NewEqualJitterRetryStrategy(tc.initialInterval, tc.maxInterval, 3)
+			assert.Equal(t, tc.wantErr, err)
+			_, err = NewDecorrelatedJitterRetryStrategy(tc.initialInterval, tc.maxInterval, 3)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestFullJitterRetryStrategy_Next(t *testing.T) {
+	s, err := NewFullJitterRetryStrategy(time.Millisecond*10, time.Millisecond*50, 5)
+	require.NoError(t, err)
+	// 第 n 次重试的上界是 min(50ms, 10ms * 2^(n-1))
+	uppers := []time.Duration{time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40,
+		time.Millisecond * 50, time.Millisecond * 50}
+	for _, upper := range uppers {
+		interval, ok := s.Next()
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, interval, time.Duration(0))
+		assert.Less(t, interval, upper)
+	}
+	_, ok := s.Next()
+	assert.False(t, ok)
+}
+
+func TestEqualJitterRetryStrategy_Next(t *testing.T) {
+	s, err := NewEqualJitterRetryStrategy(time.Millisecond*10, time.Millisecond*50, 5)
+	require.NoError(t, err)
+	// 至少等待一半的退避时间
+	uppers := []time.Duration{time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 40,
+		time.Millisecond * 50, time.Millisecond * 50}
+	for _, upper := range uppers {
+		interval, ok := s.Next()
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, interval, upper/2)
+		assert.Less(t, interval, upper)
+	}
+	_, ok := s.Next()
+	assert.False(t, ok)
+}
+
+func TestDecorrelatedJitterRetryStrategy_Next(t *testing.T) {
+	initial, max := time.Millisecond*10, time.Millisecond*100
+	s, err := NewDecorrelatedJitterRetryStrategy(initial, max, 20)
+	require.NoError(t, err)
+	// 每一次都在 [initial, min(max, 上一次 * 3)) 之间
+	prev := initial
+	for i := 0; i < 20; i++ {
+		interval, ok := s.Next()
+		require.True(t, ok)
+		upper := prev * 3
+		if upper > max {
+			upper = max
+		}
+		assert.GreaterOrEqual(t, interval, initial)
+		assert.Less(t, interval, upper)
+		prev = interval
+	}
+	_, ok := s.Next()
+	assert.False(t, ok)
+}
+
+func TestJitterRetryStrategy_Spread(t *testing.T) {
+	full, err := NewFullJitterRetryStrategy(time.Second, time.Second, 0)
+	require.NoError(t, err)
+	equal, err := NewEqualJitterRetryStrategy(time.Second, time.Second, 0)
+	require.NoError(t, err)
+	decorrelated, err := NewDecorrelatedJitterRetryStrategy(time.Millisecond, time.Second, 0)
+	require.NoError(t, err)
+
+	// 抖动之后的间隔是分散开的，而不是集中在同一个值上
+	for name, s := range map[string]Strategy{"full": full, "equal": equal, "decorrelated": decorrelated} {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			seen := make(map[time.Duration]struct{})
+			for i := 0; i < 100; i++ {
+				interval, ok := s.Next()
+				require.True(t, ok)
+				seen[interval] = struct{}{}
+			}
+			assert.Greater(t, len(seen), 50)
+		})
+	}
+}
+
+func TestExponentialInterval(t *testing.T) {
+	assert.Equal(t, time.Millisecond, exponentialInterval(time.Millisecond, time.Second, 1))
+	assert.Equal(t, time.Millisecond*8, exponentialInterval(time.Millisecond, time.Second, 4))
+	assert.Equal(t, time.Second, exponentialInterval(time.Millisecond, time.Second, 11))
+	// 次数很大的时候不会溢出
+	assert.Equal(t, time.Hour, exponentialInterval(time.Millisecond, time.Hour, 1000))
+	assert.Equal(t, time.Duration(0), randDuration(0))
+	assert.Equal(t, time.Duration(0), randDuration(-time.Second))
+}
@@ -0,0 +1,144 @@
+package retry
+
+import (
+	"encoding/json"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"sync"
+	"time"
+)
+
+// 内置的策略类型
+const (
+	TypeFixed       = "fixed"
+	TypeExponential = "exponential"
+)
+
+// 指数退避的抖动方式
+const (
+	JitterNone         = "none"
+	JitterFull         = "full"
+	JitterEqual        = "equal"
+	JitterDecorrelated = "decorrelated"
+)
+
+// Spec 声明式的重试策略配置，可以从 JSON 或者 YAML 里面解析出来，比如
+//
+//	{"type": "exponential", "initial": "100ms", "max": "5s", "retries": 8, "jitter": "full"}
+//	{"type": "fixed", "interval": "1s", "retries": 3}
+type Spec struct {
+	// Type 策略的类型，内置了 fixed 和 exponential，也可以通过 Register 注册自定义的类型
+	Type string `json:"type" yaml:"type"`
+	// Interval fixed 的重试间隔
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Initial exponential 的初始重试间隔
+	Initial Duration `json:"initial,omitempty" yaml:"initial,omitempty"`
+	// Max exponential 的最大重试间隔
+	Max Duration `json:"max,omitempty" yaml:"max,omitempty"`
+	// Retries 最大重试次数，0 表示无限重试
+	Retries int32 `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// Jitter exponential 的抖动方式，none、full、equal 或者 decorrelated，默认是 none
+	Jitter string `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// MaxElapsed 重试的总耗时，0 表示不限制，参考 MaxElapsed
+	MaxElapsed Duration `json:"max_elapsed,omitempty" yaml:"max_elapsed,omitempty"`
+	// Options 自定义策略的其它参数
+	Options map[string]any `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// Builder 根据 Spec 创建重试策略
+type Builder func(spec Spec) (Strategy, error)
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = map[string]Builder{
+		TypeFixed:       buildFixed,
+		TypeExponential: buildExponential,
+	}
+)
+
+// Register 注册自定义的策略类型，已经存在的类型会被覆盖
+// 和 database/sql.Register 一样，一般在 init 里面调用，typ 为空或者 builder 为 nil 的时候直接 panic
+func Register(typ string, builder Builder) {
+	if typ == "" {
+		panic("retry: Register 的 typ 不能为空")
+	}
+	if builder == nil {
+		panic("retry: Register 的 builder 不能为 nil, typ: " + typ)
+	}
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	builders[typ] = builder
+}
+
+// Build 根据 Spec 创建重试策略，参数不合法的时候返回错误
+func Build(spec Spec) (Strategy, error) {
+	buildersMutex.RLock()
+	builder, ok := builders[spec.Type]
+	buildersMutex.RUnlock()
+	if !ok {
+		return nil, common.NewErrInvalidArgument("type", spec.Type)
+	}
+	if spec.MaxElapsed < 0 {
+		return nil, common.NewErrInvalidIntervalValue(time.Duration(spec.MaxElapsed))
+	}
+	s, err := builder(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.MaxElapsed > 0 {
+		s = MaxElapsed(s, time.Duration(spec.MaxElapsed))
+	}
+	return s, nil
+}
+
+func buildFixed(spec Spec) (Strategy, error) {
+	return NewFixedIntervalRetryStrategy(time.Duration(spec.Interval), spec.Retries)
+}
+
+func buildExponential(spec Spec) (Strategy, error) {
+	initial, max := time.Duration(spec.Initial), time.Duration(spec.Max)
+	switch spec.Jitter {
+	case "", JitterNone:
+		return NewExponentialBackoffRetryStrategy(initial, max, spec.Retries)
+	case JitterFull:
+		return NewFullJitterRetryStrategy(initial, max, spec.Retries)
+	case JitterEqual:
+		return NewEqualJitterRetryStrategy(initial, max, spec.Retries)
+	case JitterDecorrelated:
+		return NewDecorrelatedJitterRetryStrategy(initial, max, spec.Retries)
+	default:
+		return nil, common.NewErrInvalidArgument("jitter", spec.Jitter)
+	}
+}
+
+// Duration 可以从 "100ms"、"5s" 这样的字符串解析出来的 time.Duration
+// JSON 里面的数字会被当成纳秒
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case string:
+		return d.UnmarshalText([]byte(val))
+	case float64:
+		*d = Duration(val)
+		return nil
+	default:
+		return common.NewErrInvalidType("duration", v)
+	}
+}
@@ -0,0 +1,195 @@
+package retry
+
+import (
+	"encoding/json"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func TestSpec_Decode(t *testing.T) {
+	want := Spec{
+		Type:       TypeExponential,
+		Initial:    Duration(time.Millisecond * 100),
+		Max:        Duration(time.Second * 5),
+		Retries:    8,
+		Jitter:     JitterFull,
+		MaxElapsed: Duration(time.Second * 3),
+	}
+	testCases := []struct {
+		name   string
+		decode func(data string, spec *Spec) error
+		data   string
+	}{
+		{
+			name: "json",
+			decode: func(data string, spec *Spec) error {
+				return json.Unmarshal([]byte(data), spec)
+			},
+			data: `{"type": "exponential", "initial": "100ms", "max": "5s", "retries": 8, "jitter": "full", "max_elapsed": "3s"}`,
+		},
+		{
+			name: "json nanoseconds",
+			decode: func(data string, spec *Spec) error {
+				return json.Unmarshal([]byte(data), spec)
+			},
+			data: `{"type": "exponential", "initial": 100000000, "max": "5s", "retries": 8, "jitter": "full", "max_elapsed": "3s"}`,
+		},
+		{
+			name: "yaml",
+			decode: func(data string, spec *Spec) error {
+				return yaml.Unmarshal([]byte(data), spec)
+			},
+			data: `
+type: exponential
+initial: 100ms
+max: 5s
+retries: 8
+jitter: full
+max_elapsed: 3s
+`,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var spec Spec
+			require.NoError(t, tc.decode(tc.data, &spec))
+			assert.Equal(t, want, spec)
+			_, err := Build(spec)
+			require.NoError(t, err)
+		})
+	}
+
+	var spec Spec
+	assert.Error(t, json.Unmarshal([]byte(`{"interval": "abc"}`), &spec))
+	assert.Equal(t, common.NewErrInvalidType("duration", true), json.Unmarshal([]byte(`{"interval": true}`), &spec))
+	assert.Error(t, yaml.Unmarshal([]byte(`interval: abc`), &spec))
+}
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     Spec
+		wantType Strategy
+		wantErr  error
+	}{
+		{
+			name:     "fixed",
+			spec:     Spec{Type: TypeFixed, Interval: Duration(time.Second), Retries: 3},
+			wantType: &FixedIntervalRetryStrategy{},
+		},
+		{
+			name:     "exponential",
+			spec:     Spec{Type: TypeExponential, Initial: Duration(time.Millisecond), Max: Duration(time.Second)},
+			wantType: &ExponentialBackoffRetryStrategy{},
+		},
+		{
+			name:     "full jitter",
+			spec:     Spec{Type: TypeExponential, Initial: Duration(time.Millisecond), Max: Duration(time.Second), Jitter: JitterFull},
+			wantType: &FullJitterRetryStrategy{},
+		},
+		{
+			name:     "equal jitter",
+			spec:     Spec{Type: TypeExponential, Initial: Duration(time.Millisecond), Max: Duration(time.Second), Jitter: JitterEqual},
+			wantType: &EqualJitterRetryStrategy{},
+		},
+		{
+			name:     "decorrelated jitter",
+			spec:     Spec{Type: TypeExponential, Initial: Duration(time.Millisecond), Max: Duration(time.Second), Jitter: JitterDecorrelated},
+			wantType: &DecorrelatedJitterRetryStrategy{},
+		},
+		{
+			name:     "max elapsed",
+			spec:     Spec{Type: TypeFixed, Interval: Duration(time.Second), MaxElapsed: Duration(time.Second * 3)},
+			wantType: &elapsedStrategy{},
+		},
+		{
+			name:    "unknown type",
+			spec:    Spec{Type: "unknown"},
+			wantErr: common.NewErrInvalidArgument("type", "unknown"),
+		},
+		{
+			name:    "invalid interval",
+			spec:    Spec{Type: TypeFixed},
+			wantErr: common.NewErrInvalidIntervalValue(0),
+		},
+		{
+			name:    "invalid max interval",
+			spec:    Spec{Type: TypeExponential, Initial: Duration(time.Second), Max: Duration(time.Millisecond)},
+			wantErr: common.NewErrInvalidMaxIntervalValue(time.Millisecond, time.Second),
+		},
+		{
+			name:    "invalid jitter",
+			spec:    Spec{Type: TypeExponential, Initial: Duration(time.Millisecond), Max: Duration(time.Second), Jitter: "half"},
+			wantErr: common.NewErrInvalidArgument("jitter", "half"),
+		},
+		{
+			name:    "invalid max elapsed",
+			spec:    Spec{Type: TypeFixed, Interval: Duration(time.Second), MaxElapsed: Duration(-time.Second)},
+			wantErr: common.NewErrInvalidIntervalValue(-time.Second),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Build(tc.spec)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.IsType(t, tc.wantType, s)
+		})
+	}
+}
+
+func TestBuild_MaxElapsed(t *testing.T) {
+	s, err := Build(Spec{Type: TypeFixed, Interval: Duration(time.Hour), MaxElapsed: Duration(time.Millisecond * 50)})
+	require.NoError(t, err)
+	// 总耗时的限制会缩短重试间隔
+	interval, ok := Start(s).Next()
+	require.True(t, ok)
+	assert.Less(t, interval, time.Millisecond*50)
+}
+
+func TestRegister(t *testing.T) {
+	const typ = "test-constant"
+	Register(typ, func(spec Spec) (Strategy, error) {
+		interval, ok := spec.Options["interval_ms"].(int)
+		if !ok {
+			return nil, common.NewErrInvalidArgument("interval_ms", spec.Options["interval_ms"])
+		}
+		return NewFixedIntervalRetryStrategy(time.Duration(interval)*time.Millisecond, spec.Retries)
+	})
+
+	var spec Spec
+	require.NoError(t, yaml.Unmarshal([]byte(`
+type: test-constant
+retries: 2
+options:
+  interval_ms: 20
+`), &spec))
+	s, err := Build(spec)
+	require.NoError(t, err)
+	interval, ok := s.Next()
+	require.True(t, ok)
+	assert.Equal(t, time.Millisecond*20, interval)
+
+	spec.Options = nil
+	_, err = Build(spec)
+	assert.Equal(t, common.NewErrInvalidArgument("interval_ms", nil), err)
+}
+
+func TestRegister_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("", buildFixed)
+	})
+	assert.Panics(t, func() {
+		Register("test-nil", nil)
+	})
+	_, err := Build(Spec{Type: "test-nil"})
+	assert.Equal(t, common.NewErrInvalidArgument("type", "test-nil"), err)
+}
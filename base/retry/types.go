@@ -1,9 +1,50 @@
 package retry
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 //Strategy 重试策略接口
 type Strategy interface {
 	// Next 返回下一次重试的间隔，如果不需要继续重试，那么第二参数返回 false
 	Next() (time.Duration, bool)
 }
+
+// Backoff 一次调用里面的重试状态，只能在一次调用里面使用，不要在多个 goroutine 之间共享
+type Backoff interface {
+	Strategy
+	// Reset 重置重试状态，可以从头开始重试
+	Reset()
+}
+
+// Policy 不可变的重试策略
+// 每一次调用都通过 Start 创建一个独立的 Backoff，
+// 所以同一个 Policy 可以被多次调用、多个 goroutine 共享，互相不会消耗对方的重试次数
+type Policy interface {
+	Start() Backoff
+}
+
+// Start 如果 s 是 Policy，那么创建一个新的 Backoff，否则直接返回 s
+// 接收 Strategy 的地方在开始重试之前应该先调用 Start
+func Start(s Strategy) Strategy {
+	if p, ok := s.(Policy); ok {
+		return p.Start()
+	}
+	return s
+}
+
+// ContextPolicy 需要知道这次调用的 ctx 的 Policy，比如 MaxElapsed 要参考 ctx 的 deadline
+type ContextPolicy interface {
+	Policy
+	StartContext(ctx context.Context) Backoff
+}
+
+// StartContext 和 Start 一样，但是 s 是 ContextPolicy 的时候会把这次调用的 ctx 传进去
+// Do 在开始重试之前会调用它
+func StartContext(ctx context.Context, s Strategy) Strategy {
+	if p, ok := s.(ContextPolicy); ok {
+		return p.StartContext(ctx)
+	}
+	return Start(s)
+}
@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPoliciesForTest(t *testing.T, maxRetries int32) map[string]Strategy {
+	fixed, err := NewFixedIntervalRetryStrategy(time.Millisecond, maxRetries)
+	require.NoError(t, err)
+	exponential, err := NewExponentialBackoffRetryStrategy(time.Millisecond, time.Second, maxRetries)
+	require.NoError(t, err)
+	full, err := NewFullJitterRetryStrategy(time.Millisecond, time.Second, maxRetries)
+	require.NoError(t, err)
+	equal, err := NewEqualJitterRetryStrategy(time.Millisecond, time.Second, maxRetries)
+	require.NoError(t, err)
+	decorrelated, err := NewDecorrelatedJitterRetryStrategy(time.Millisecond, time.Second, maxRetries)
+	require.NoError(t, err)
+	return map[string]Strategy{
+		"fixed":        fixed,
+		"exponential":  exponential,
+		"full":         full,
+		"equal":        equal,
+		"decorrelated": decorrelated,
+	}
+}
+
+// drain 一直调用 Next 直到不允许重试，返回重试的次数
+func drain(s Strategy) int {
+	cnt := 0
+	for {
+		if _, ok := s.Next(); !ok {
+			return cnt
+		}
+		cnt++
+	}
+}
+
+func TestPolicy_StartConcurrently(t *testing.T) {
+	for name, s := range newPoliciesForTest(t, 5) {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			p, ok := s.(Policy)
+			require.True(t, ok)
+			// 每一次 Start 都有自己的重试次数，互相不会消耗对方的次数
+			var wg sync.WaitGroup
+			counts := make([]int, 10)
+			for i := range counts {
+				i := i
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					counts[i] = drain(p.Start())
+				}()
+			}
+			wg.Wait()
+			for _, cnt := range counts {
+				assert.Equal(t, 5, cnt)
+			}
+			// 原来的策略没有被消耗
+			assert.Equal(t, 5, drain(Start(s)))
+			assert.Equal(t, 5, drain(s))
+		})
+	}
+}
+
+func TestBackoff_Reset(t *testing.T) {
+	for name, s := range newPoliciesForTest(t, 3) {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			b := s.(Policy).Start()
+			first, ok := b.Next()
+			require.True(t, ok)
+			assert.Equal(t, 2, drain(b))
+			// 重置之后从头开始
+			b.Reset()
+			interval, ok := b.Next()
+			require.True(t, ok)
+			assert.LessOrEqual(t, interval, time.Millisecond*3)
+			assert.LessOrEqual(t, first, time.Millisecond*3)
+			assert.Equal(t, 2, drain(b))
+		})
+	}
+}
+
+func TestExponentialBackoffRetryStrategy_Reset(t *testing.T) {
+	s, err := NewExponentialBackoffRetryStrategy(time.Millisecond, time.Millisecond*4, 0)
+	require.NoError(t, err)
+	for _, want := range []time.Duration{time.Millisecond, time.Millisecond * 2, time.Millisecond * 4, time.Millisecond * 4} {
+		interval, ok := s.Next()
+		require.True(t, ok)
+		assert.Equal(t, want, interval)
+	}
+	// 重置之后间隔也从初始间隔开始
+	s.Reset()
+	interval, ok := s.Next()
+	require.True(t, ok)
+	assert.Equal(t, time.Millisecond, interval)
+}
+
+type plainStrategy struct{}
+
+func (plainStrategy) Next() (time.Duration, bool) {
+	return time.Millisecond, true
+}
+
+func TestStart(t *testing.T) {
+	// 不是 Policy 的时候原样返回
+	s := plainStrategy{}
+	assert.Equal(t, Strategy(s), Start(s))
+
+	fixed, err := NewFixedIntervalRetryStrategy(time.Millisecond, 1)
+	require.NoError(t, err)
+	started := Start(fixed)
+	assert.NotSame(t, fixed, started)
+}
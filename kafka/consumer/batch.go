@@ -0,0 +1,209 @@
+package consumer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/colin-water/go_tool_libaray/base/retry"
+	"log"
+	"time"
+)
+
+// Message 解码之后的消息
+type Message[T any] struct {
+	// Msg 原始的消息
+	Msg   *sarama.ConsumerMessage
+	Value T
+}
+
+// BatchFunc 批量处理消息，返回错误的时候整批消息会被重试
+type BatchFunc[T any] func(ctx context.Context, msgs []Message[T]) error
+
+type batchConfig struct {
+	batchSize int
+	linger    time.Duration
+	retry     retry.Strategy
+	// onDecodeError 返回 nil 的时候跳过这条消息，否则停止消费
+	onDecodeError func(msg *sarama.ConsumerMessage, err error) error
+	// onBatchError 返回 nil 的时候跳过这一批消息，否则停止消费
+	onBatchError func(ctx context.Context, msgs []*sarama.ConsumerMessage, err error) error
+}
+
+// BatchOption BatchHandler 的可选配置
+type BatchOption func(c *batchConfig)
+
+// WithBatchSize 一批最多多少条消息，默认是 10
+func WithBatchSize(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.batchSize = n
+	}
+}
+
+// WithLinger 收到一批里面的第一条消息之后，最多等多久凑够一批，默认是 1 秒
+func WithLinger(d time.Duration) BatchOption {
+	return func(c *batchConfig) {
+		c.linger = d
+	}
+}
+
+// WithRetry 一批消息处理失败之后的重试策略，默认不重试
+// 每一批都会通过 retry.Start 开始新的重试，所以推荐使用 retry.Policy
+func WithRetry(s retry.Strategy) BatchOption {
+	return func(c *batchConfig) {
+		c.retry = s
+	}
+}
+
+// WithDecodeErrorHandler 解码失败的时候回调，返回 nil 的时候跳过这条消息，否则停止消费
+// 默认打印日志并且跳过
+func WithDecodeErrorHandler(fn func(msg *sarama.ConsumerMessage, err error) error) BatchOption {
+	return func(c *batchConfig) {
+		c.onDecodeError = fn
+	}
+}
+
+// WithBatchErrorHandler 一批消息重试之后还是处理失败的时候回调，msgs 里面没有解码失败的消息，
+// 返回 nil 的时候提交这一批的偏移量并继续消费，否则停止消费。
+// 比如用 Router.Route 把每一条消息转发到重试 topic 或者死信队列。
+// 默认直接返回 err，也就是结束整个会话。消费结束（比如 rebalance）导致的失败不会回调
+func WithBatchErrorHandler(fn func(ctx context.Context, msgs []*sarama.ConsumerMessage, err error) error) BatchOption {
+	return func(c *batchConfig) {
+		c.onBatchError = fn
+	}
+}
+
+// BatchHandler 批量消费消息的 sarama.ConsumerGroupHandler
+// 凑够 batchSize 条消息或者等待超过 linger 之后，把这一批消息解码之后交给 BatchFunc 处理，
+// 处理失败按照重试策略重试，整批都处理成功之后才会提交这一批的偏移量。
+// 重试也失败的时候交给 WithBatchErrorHandler 设置的回调处理。
+// 默认 ConsumeClaim 会返回错误，sarama 会因此结束整个会话，所有分区都停止消费，
+// 重新加入消费组之后从没有提交的偏移量开始消费，又会读到这一批消息。
+// 一直处理失败的消息会让消费组反复 rebalance，应该用回调转发到重试 topic 或者死信队列。
+// 会话结束（比如 rebalance）导致的失败不会返回错误，偏移量也不会提交
+type BatchHandler[T any] struct {
+	decoder Decoder[T]
+	fn      BatchFunc[T]
+	cfg     batchConfig
+}
+
+// NewBatchHandler 创建批量消费消息的 sarama.ConsumerGroupHandler
+func NewBatchHandler[T any](decoder Decoder[T], fn BatchFunc[T], opts ...BatchOption) *BatchHandler[T] {
+	cfg := batchConfig{
+		batchSize: 10,
+		linger:    time.Second,
+		onDecodeError: func(msg *sarama.ConsumerMessage, err error) error {
+			log.Println("consumer: 解码消息失败，跳过", msg.Topic, msg.Partition, msg.Offset, err)
+			return nil
+		},
+		onBatchError: func(ctx context.Context, msgs []*sarama.ConsumerMessage, err error) error {
+			return err
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = 1
+	}
+	return &BatchHandler[T]{
+		decoder: decoder,
+		fn:      fn,
+		cfg:     cfg,
+	}
+}
+
+func (h *BatchHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *BatchHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		batch, more := h.collect(ctx, claim.Messages())
+		if len(batch) > 0 {
+			if err := h.handle(ctx, batch); err != nil {
+				if ctx.Err() != nil {
+					// 会话已经结束，没有提交的消息会被重新消费，不算失败
+					return nil
+				}
+				return err
+			}
+			// 同一个分区里面，标记最后一条就相当于标记了整批
+			session.MarkMessage(batch[len(batch)-1], "")
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// collect 收集一批消息，第二个返回值是 false 的时候说明消费已经结束
+func (h *BatchHandler[T]) collect(ctx context.Context, msgs <-chan *sarama.ConsumerMessage) ([]*sarama.ConsumerMessage, bool) {
+	batch := make([]*sarama.ConsumerMessage, 0, h.cfg.batchSize)
+	// 先等到第一条消息，没有消息的时候不需要空转
+	select {
+	case msg, ok := <-msgs:
+		if !ok {
+			return batch, false
+		}
+		batch = append(batch, msg)
+	case <-ctx.Done():
+		return batch, false
+	}
+
+	timer := time.NewTimer(h.cfg.linger)
+	defer timer.Stop()
+	for len(batch) < h.cfg.batchSize {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch, true
+		case <-ctx.Done():
+			// 已经收到的消息还是处理完，处理的时候 ctx 已经结束，一般会失败
+			return batch, false
+		}
+	}
+	return batch, true
+}
+
+// handle 解码并且处理一批消息
+func (h *BatchHandler[T]) handle(ctx context.Context, batch []*sarama.ConsumerMessage) error {
+	msgs := make([]Message[T], 0, len(batch))
+	for _, msg := range batch {
+		val, err := h.decoder.Decode(msg.Value)
+		if err != nil {
+			if err = h.cfg.onDecodeError(msg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		msgs = append(msgs, Message[T]{Msg: msg, Value: val})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	var err error
+	if h.cfg.retry == nil {
+		err = h.fn(ctx, msgs)
+	} else {
+		err = retry.Do(ctx, h.cfg.retry, func(ctx context.Context) error {
+			return h.fn(ctx, msgs)
+		})
+	}
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	// 解码失败被跳过的消息已经处理过了，不再交给回调
+	failed := make([]*sarama.ConsumerMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		failed = append(failed, msg.Msg)
+	}
+	return h.cfg.onBatchError(ctx, failed, err)
+}
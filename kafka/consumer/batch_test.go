@@ -0,0 +1,182 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/colin-water/go_tool_libaray/base/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type article struct {
+	Aid int64 `json:"aid"`
+}
+
+func TestBatchHandler_ConsumeClaim(t *testing.T) {
+	var batches [][]int64
+	h := NewBatchHandler[article](JSONDecoder[article]{}, func(ctx context.Context, msgs []Message[article]) error {
+		aids := make([]int64, 0, len(msgs))
+		for _, msg := range msgs {
+			aids = append(aids, msg.Value.Aid)
+		}
+		batches = append(batches, aids)
+		return nil
+	}, WithBatchSize(2), WithLinger(time.Millisecond*10))
+
+	claim := newMockClaim(newMessages(`{"aid":1}`, `{"aid":2}`, `不是 JSON`, `{"aid":4}`, `{"aid":5}`)...)
+	close(claim.msgs)
+	session := newMockSession(context.Background())
+	require.NoError(t, h.ConsumeClaim(session, claim))
+	// 解码失败的消息被跳过，但是偏移量照样提交
+	assert.Equal(t, [][]int64{{1, 2}, {4}, {5}}, batches)
+	assert.Equal(t, []int64{2, 4, 5}, session.Marked())
+}
+
+func TestBatchHandler_Linger(t *testing.T) {
+	batches := make(chan int, 10)
+	h := NewBatchHandler[string](StringDecoder{}, func(ctx context.Context, msgs []Message[string]) error {
+		batches <- len(msgs)
+		return nil
+	}, WithBatchSize(10), WithLinger(time.Millisecond*20))
+
+	claim := newMockClaim(newMessages("a", "b")...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := newMockSession(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.ConsumeClaim(session, claim)
+	}()
+	// 凑不够一批，等待 linger 之后也要处理
+	select {
+	case n := <-batches:
+		assert.Equal(t, 2, n)
+	case <-time.After(time.Second):
+		t.Fatal("没有等到这一批消息")
+	}
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []int64{2}, session.Marked())
+}
+
+func TestBatchHandler_SessionDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewBatchHandler[string](StringDecoder{}, func(ctx context.Context, msgs []Message[string]) error {
+		// 处理的时候发生了 rebalance
+		cancel()
+		return ctx.Err()
+	}, WithBatchSize(2), WithLinger(time.Millisecond*10))
+
+	claim := newMockClaim(newMessages("a", "b")...)
+	session := newMockSession(ctx)
+	// 会话结束不算失败，但是这一批的偏移量不能提交
+	require.NoError(t, h.ConsumeClaim(session, claim))
+	assert.Empty(t, session.Marked())
+}
+
+func TestBatchHandler_Retry(t *testing.T) {
+	bizErr := errors.New("处理失败")
+	testCases := []struct {
+		name string
+		// 前 failures 次处理失败
+		failures   int
+		wantErr    error
+		wantCalls  int
+		wantMarked []int64
+	}{
+		{name: "重试之后成功", failures: 2, wantCalls: 3, wantMarked: []int64{2}},
+		{name: "重试也失败", failures: 10, wantErr: bizErr, wantCalls: 4},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := retry.NewFixedIntervalRetryStrategy(time.Millisecond, 3)
+			require.NoError(t, err)
+			calls := 0
+			h := NewBatchHandler[string](StringDecoder{}, func(ctx context.Context, msgs []Message[string]) error {
+				calls++
+				// 每次重试拿到的都是完整的一批
+				assert.Len(t, msgs, 2)
+				if calls <= tc.failures {
+					return bizErr
+				}
+				return nil
+			}, WithBatchSize(2), WithRetry(s))
+
+			claim := newMockClaim(newMessages("a", "b")...)
+			close(claim.msgs)
+			session := newMockSession(context.Background())
+			err = h.ConsumeClaim(session, claim)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantMarked, session.Marked())
+		})
+	}
+}
+
+func TestBatchHandler_BatchErrorHandler(t *testing.T) {
+	bizErr := errors.New("处理失败")
+	testCases := []struct {
+		name       string
+		handlerErr error
+		wantErr    error
+		wantFailed [][]int64
+		wantMarked []int64
+	}{
+		// 交给回调处理之后继续消费，不会卡住这个分区
+		{name: "跳过", wantFailed: [][]int64{{0}, {2}}, wantMarked: []int64{2, 3}},
+		{name: "停止消费", handlerErr: bizErr, wantErr: bizErr, wantFailed: [][]int64{{0}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var failed [][]int64
+			h := NewBatchHandler[string](DecoderFunc[string](func(data []byte) (string, error) {
+				if string(data) == "b" {
+					return "", errors.New("解码失败")
+				}
+				return string(data), nil
+			}), func(ctx context.Context, msgs []Message[string]) error {
+				return bizErr
+			}, WithBatchSize(2), WithBatchErrorHandler(func(ctx context.Context, msgs []*sarama.ConsumerMessage, err error) error {
+				assert.Equal(t, bizErr, err)
+				// 解码失败的消息不会交给回调
+				offsets := make([]int64, 0, len(msgs))
+				for _, msg := range msgs {
+					offsets = append(offsets, msg.Offset)
+				}
+				failed = append(failed, offsets)
+				return tc.handlerErr
+			}))
+
+			claim := newMockClaim(newMessages("a", "b", "c")...)
+			close(claim.msgs)
+			session := newMockSession(context.Background())
+			assert.Equal(t, tc.wantErr, h.ConsumeClaim(session, claim))
+			assert.Equal(t, tc.wantFailed, failed)
+			assert.Equal(t, tc.wantMarked, session.Marked())
+		})
+	}
+}
+
+func TestBatchHandler_DecodeError(t *testing.T) {
+	decodeErr := errors.New("解码失败")
+	h := NewBatchHandler[string](DecoderFunc[string](func(data []byte) (string, error) {
+		return "", decodeErr
+	}), func(ctx context.Context, msgs []Message[string]) error {
+		t.Fatal("不应该被调用")
+		return nil
+	}, WithDecodeErrorHandler(func(msg *sarama.ConsumerMessage, err error) error {
+		return err
+	}))
+	claim := newMockClaim(newMessages("a")...)
+	close(claim.msgs)
+	session := newMockSession(context.Background())
+	assert.Equal(t, decodeErr, h.ConsumeClaim(session, claim))
+	assert.Empty(t, session.Marked())
+}
@@ -0,0 +1,38 @@
+package consumer
+
+import "encoding/json"
+
+// Decoder 把消息的字节解码成 T
+type Decoder[T any] interface {
+	Decode(data []byte) (T, error)
+}
+
+// DecoderFunc 让普通的函数也能作为 Decoder
+type DecoderFunc[T any] func(data []byte) (T, error)
+
+func (f DecoderFunc[T]) Decode(data []byte) (T, error) {
+	return f(data)
+}
+
+// JSONDecoder 用 JSON 解码
+type JSONDecoder[T any] struct{}
+
+func (JSONDecoder[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// RawDecoder 直接使用消息的字节
+type RawDecoder struct{}
+
+func (RawDecoder) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// StringDecoder 把消息当成字符串
+type StringDecoder struct{}
+
+func (StringDecoder) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
@@ -0,0 +1,176 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/colin-water/go_tool_libaray/base/slice"
+	"github.com/colin-water/go_tool_libaray/kafka/producer"
+	"strconv"
+	"time"
+)
+
+// 失败的消息被转发的时候带上的 header
+const (
+	// HeaderOriginalTopic 消息最开始所在的 topic
+	HeaderOriginalTopic = "x-original-topic"
+	// HeaderOriginalPartition 消息最开始所在的分区
+	HeaderOriginalPartition = "x-original-partition"
+	// HeaderOriginalOffset 消息最开始的偏移量
+	HeaderOriginalOffset = "x-original-offset"
+	// HeaderError 最后一次处理失败的错误
+	HeaderError = "x-error"
+	// HeaderAttempt 已经处理失败的次数
+	HeaderAttempt = "x-attempt"
+	// HeaderNotBefore 重试 topic 里面的消息最早可以被处理的时间，unix 毫秒
+	HeaderNotBefore = "x-not-before"
+)
+
+// ErrAsyncProducer 转发消息用的是异步模式的生产者
+// 异步模式下发送返回 nil 只代表消息交给了 sarama，这时候标记原来的消息已经消费，发送失败的话消息就丢了
+var ErrAsyncProducer = errors.New("consumer: 转发消息必须使用同步模式的生产者")
+
+// RetryTopic 延迟重试的 topic，消息在这个 topic 里面至少等待 Delay 之后才会被处理
+type RetryTopic struct {
+	Topic string
+	Delay time.Duration
+}
+
+// Router 把处理失败的消息依次转发到重试 topic，重试都失败之后转发到死信队列
+// 比如配置了 retry-5s、retry-1m、retry-10m 三个重试 topic，
+// 第一次失败转发到 retry-5s，在 retry-5s 里面又失败了转发到 retry-1m，以此类推，最后进入死信队列。
+// 主 topic 和所有重试 topic 的消费者都要使用 Router.Middleware，它会负责等待消息的延迟时间。
+// producer 必须使用同步模式，转发成功之后原来的消息才会被标记为已经消费，使用异步模式的时候转发会返回 ErrAsyncProducer
+type Router struct {
+	producer    *producer.Producer
+	retryTopics []RetryTopic
+	dlq         string
+}
+
+// NewRouter 创建失败消息的路由，dlq 是死信队列的 topic
+func NewRouter(p *producer.Producer, dlq string, retryTopics ...RetryTopic) *Router {
+	return &Router{
+		producer:    p,
+		retryTopics: retryTopics,
+		dlq:         dlq,
+	}
+}
+
+// Middleware 处理失败的时候把消息转发出去，转发成功就当作处理成功，转发失败的时候返回转发的错误
+// 消息还没有到可以处理的时间的时候，会先等待。
+// 消费结束（比如 rebalance）导致的失败不会转发，直接返回错误，消息之后会被重新消费
+func (r *Router) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if err := waitNotBefore(ctx, msg); err != nil {
+			return err
+		}
+		err := next(ctx, msg)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		return r.Route(ctx, msg, err)
+	}
+}
+
+// Route 把处理失败的消息转发到下一个重试 topic 或者死信队列
+func (r *Router) Route(ctx context.Context, msg *sarama.ConsumerMessage, cause error) error {
+	attempt := 1
+	if val, ok := header(msg.Headers, HeaderAttempt); ok {
+		if n, err := strconv.Atoi(val); err == nil {
+			attempt = n + 1
+		}
+	}
+
+	topic := r.dlq
+	var notBefore time.Time
+	if attempt <= len(r.retryTopics) {
+		rt := r.retryTopics[attempt-1]
+		topic = rt.Topic
+		notBefore = time.Now().Add(rt.Delay)
+	}
+
+	headers := map[string]string{
+		HeaderError:   cause.Error(),
+		HeaderAttempt: strconv.Itoa(attempt),
+	}
+	// 只在第一次失败的时候记录原始的位置
+	if _, ok := header(msg.Headers, HeaderOriginalTopic); !ok {
+		headers[HeaderOriginalTopic] = msg.Topic
+		headers[HeaderOriginalPartition] = strconv.FormatInt(int64(msg.Partition), 10)
+		headers[HeaderOriginalOffset] = strconv.FormatInt(msg.Offset, 10)
+	}
+	if !notBefore.IsZero() {
+		headers[HeaderNotBefore] = strconv.FormatInt(notBefore.UnixMilli(), 10)
+	}
+	return forward(ctx, r.producer, msg, topic, headers, []string{HeaderNotBefore})
+}
+
+// waitNotBefore 等到消息可以被处理的时间
+func waitNotBefore(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	val, ok := header(msg.Headers, HeaderNotBefore)
+	if !ok {
+		return nil
+	}
+	ms, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil
+	}
+	delay := time.Until(time.UnixMilli(ms))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forward 把消息转发到 topic
+// 原来的 header 会被保留，set 里面的 header 会被覆盖，remove 里面的 header 会被删掉。
+// trace id 会通过 ctx 传递给 producer，不会重复。
+// p 不是同步模式的时候返回 ErrAsyncProducer，不会发送
+func forward(ctx context.Context, p *producer.Producer, msg *sarama.ConsumerMessage,
+	topic string, set map[string]string, remove []string) error {
+	if !p.IsSync() {
+		return ErrAsyncProducer
+	}
+	ctx = producer.ContextWithHeaders(ctx, msg.Headers)
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+len(set))
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		key := string(h.Key)
+		if _, ok := set[key]; ok || key == producer.TraceIDHeader || slice.Contains(remove, key) {
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	for key, val := range set {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(val)})
+	}
+
+	pm := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	return p.SendMessage(ctx, pm)
+}
+
+// header 找到 key 对应的 header 的值
+func header(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,365 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/colin-water/go_tool_libaray/kafka/producer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// headersOf 把 header 转换成 map
+func headersOf(headers []sarama.RecordHeader) map[string]string {
+	res := make(map[string]string, len(headers))
+	for _, h := range headers {
+		res[string(h.Key)] = string(h.Value)
+	}
+	return res
+}
+
+func newRouterForTest(t *testing.T) (*Router, *mocks.SyncProducer) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := producer.NewSyncProducerFrom(mp)
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return NewRouter(p, "dlq",
+		RetryTopic{Topic: "retry-5s", Delay: time.Second * 5},
+		RetryTopic{Topic: "retry-1m", Delay: time.Minute}), mp
+}
+
+func TestRouter_Middleware(t *testing.T) {
+	bizErr := errors.New("处理失败")
+	testCases := []struct {
+		name    string
+		headers []*sarama.RecordHeader
+		// 处理的结果
+		handleErr error
+		// 处理之前 ctx 已经结束
+		canceled bool
+		// 期望转发到的 topic，为空的时候不转发
+		wantTopic   string
+		wantHeaders map[string]string
+		wantErr     error
+	}{
+		{name: "处理成功"},
+		{
+			// rebalance 的时候不转发，不然会白白用掉一次重试
+			name:      "ctx 结束",
+			canceled:  true,
+			handleErr: context.Canceled,
+			wantErr:   context.Canceled,
+		},
+		{
+			name:      "第一次失败",
+			handleErr: bizErr,
+			headers: []*sarama.RecordHeader{
+				{Key: []byte(producer.TraceIDHeader), Value: []byte("trace-123")},
+				{Key: []byte("app"), Value: []byte("test")},
+			},
+			wantTopic: "retry-5s",
+			wantHeaders: map[string]string{
+				HeaderOriginalTopic:     "order",
+				HeaderOriginalPartition: "3",
+				HeaderOriginalOffset:    "100",
+				HeaderError:             bizErr.Error(),
+				HeaderAttempt:           "1",
+				producer.TraceIDHeader:  "trace-123",
+				"app":                   "test",
+			},
+		},
+		{
+			name:      "重试 topic 里面又失败了",
+			handleErr: bizErr,
+			headers: []*sarama.RecordHeader{
+				{Key: []byte(HeaderOriginalTopic), Value: []byte("main")},
+				{Key: []byte(HeaderOriginalPartition), Value: []byte("1")},
+				{Key: []byte(HeaderOriginalOffset), Value: []byte("10")},
+				{Key: []byte(HeaderAttempt), Value: []byte("1")},
+			},
+			wantTopic: "retry-1m",
+			wantHeaders: map[string]string{
+				HeaderOriginalTopic:     "main",
+				HeaderOriginalPartition: "1",
+				HeaderOriginalOffset:    "10",
+				HeaderError:             bizErr.Error(),
+				HeaderAttempt:           "2",
+			},
+		},
+		{
+			name:      "重试都失败了进入死信队列",
+			handleErr: bizErr,
+			headers: []*sarama.RecordHeader{
+				{Key: []byte(HeaderOriginalTopic), Value: []byte("main")},
+				{Key: []byte(HeaderAttempt), Value: []byte("2")},
+				{Key: []byte(HeaderNotBefore), Value: []byte("0")},
+			},
+			wantTopic: "dlq",
+			wantHeaders: map[string]string{
+				HeaderOriginalTopic: "main",
+				HeaderError:         bizErr.Error(),
+				HeaderAttempt:       "3",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, mp := newRouterForTest(t)
+			msg := &sarama.ConsumerMessage{
+				Topic:     "order",
+				Partition: 3,
+				Offset:    100,
+				Key:       []byte("oid-1"),
+				Value:     []byte("hello"),
+				Headers:   tc.headers,
+			}
+			if tc.wantTopic != "" {
+				mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+					assert.Equal(t, tc.wantTopic, pm.Topic)
+					key, _ := pm.Key.Encode()
+					assert.Equal(t, "oid-1", string(key))
+					val, _ := pm.Value.Encode()
+					assert.Equal(t, "hello", string(val))
+					headers := headersOf(pm.Headers)
+					if tc.wantTopic != "dlq" {
+						notBefore, err := strconv.ParseInt(headers[HeaderNotBefore], 10, 64)
+						require.NoError(t, err)
+						assert.Greater(t, notBefore, time.Now().UnixMilli())
+						delete(headers, HeaderNotBefore)
+					}
+					assert.Equal(t, tc.wantHeaders, headers)
+					return nil
+				})
+			}
+			h := router.Middleware(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				return tc.handleErr
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.canceled {
+				cancel()
+			}
+			assert.Equal(t, tc.wantErr, h(ctx, msg))
+		})
+	}
+}
+
+func TestRouter_RouteFailed(t *testing.T) {
+	router, mp := newRouterForTest(t)
+	sendErr := errors.New("发送失败")
+	mp.ExpectSendMessageAndFail(sendErr)
+	h := router.Middleware(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("处理失败")
+	})
+	// 转发失败的时候不能当作处理成功
+	assert.Equal(t, sendErr, h(context.Background(), &sarama.ConsumerMessage{Topic: "order"}))
+}
+
+func TestRouter_AsyncProducer(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	p := producer.NewAsyncProducerFrom(mocks.NewAsyncProducer(t, cfg))
+	defer p.Close()
+
+	// 异步模式下发送返回 nil 不代表转发成功了，不能把原来的消息当作处理成功
+	router := NewRouter(p, "dlq")
+	h := router.Middleware(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("处理失败")
+	})
+	assert.Equal(t, ErrAsyncProducer, h(context.Background(), &sarama.ConsumerMessage{Topic: "order"}))
+
+	msgs := newMessages("a")
+	session, errs := consumeRedrive(t, NewRedriveHandler(p, WithRedriveTarget("order")), newMockClaim(msgs...))
+	assert.Equal(t, []error{ErrAsyncProducer}, errs)
+	assert.Empty(t, session.Marked())
+}
+
+func TestRouter_WaitNotBefore(t *testing.T) {
+	router, _ := newRouterForTest(t)
+	var handledAt time.Time
+	h := router.Middleware(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handledAt = time.Now()
+		return nil
+	})
+	notBefore := time.Now().Add(time.Millisecond * 50)
+	msg := &sarama.ConsumerMessage{
+		Topic: "retry-5s",
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderNotBefore), Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+		},
+	}
+	require.NoError(t, h(context.Background(), msg))
+	assert.False(t, handledAt.Before(notBefore.Truncate(time.Millisecond)))
+
+	// 等待的时候 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	msg.Headers[0].Value = []byte(strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10))
+	assert.Equal(t, context.DeadlineExceeded, h(ctx, msg))
+}
+
+// consumeRedrive 和 sarama 一样在同一个会话里面并发消费所有的分区，
+// 等到 Done 或者有分区返回错误之后结束会话，返回每个分区的 ConsumeClaim 的结果
+func consumeRedrive(t *testing.T, h *RedriveHandler, claims ...*mockClaim) (*mockSession, []error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	partitions := make([]int32, 0, len(claims))
+	for i := range claims {
+		partitions = append(partitions, int32(i))
+	}
+	session := newMockSession(ctx)
+	session.claims = map[string][]int32{"dlq": partitions}
+	require.NoError(t, h.Setup(session))
+
+	results := make(chan error, len(claims))
+	for _, claim := range claims {
+		claim := claim
+		go func() {
+			results <- h.ConsumeClaim(session, claim)
+		}()
+	}
+	var errs []error
+	select {
+	case <-h.Done():
+		// 处理完了也不能提前返回，否则整个会话都会被结束
+		select {
+		case err := <-results:
+			t.Fatalf("会话结束之前 ConsumeClaim 就返回了: %v", err)
+		case <-time.After(time.Millisecond * 20):
+		}
+	case err := <-results:
+		// 返回错误会结束整个会话
+		errs = append(errs, err)
+	case <-time.After(time.Second):
+		t.Fatal("没有等到所有的分区处理完")
+	}
+	cancel()
+	for len(errs) < len(claims) {
+		errs = append(errs, <-results)
+	}
+	require.NoError(t, h.Cleanup(session))
+	return session, errs
+}
+
+func TestRedriveHandler_ConsumeClaim(t *testing.T) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := producer.NewSyncProducerFrom(mp)
+	defer p.Close()
+	h := NewRedriveHandler(p, WithRedriveFilter(func(msg *sarama.ConsumerMessage) bool {
+		return string(msg.Value) != "skip"
+	}))
+
+	msgs := newMessages("a", "skip", "b")
+	for _, msg := range msgs {
+		msg.Topic = "dlq"
+		msg.Headers = []*sarama.RecordHeader{
+			{Key: []byte(HeaderOriginalTopic), Value: []byte("order")},
+			{Key: []byte(HeaderAttempt), Value: []byte("3")},
+			{Key: []byte(HeaderError), Value: []byte("处理失败")},
+			{Key: []byte("app"), Value: []byte("test")},
+		}
+	}
+	for i := 0; i < 2; i++ {
+		mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+			assert.Equal(t, "order", pm.Topic)
+			assert.Equal(t, map[string]string{"app": "test"}, headersOf(pm.Headers))
+			return nil
+		})
+	}
+	// 消息通道没有关闭，处理完已有的消息之后也要完成
+	session, errs := consumeRedrive(t, h, newMockClaim(msgs...))
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, []int64{1, 2, 3}, session.Marked())
+}
+
+func TestRedriveHandler_MultiplePartitions(t *testing.T) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := producer.NewSyncProducerFrom(mp)
+	defer p.Close()
+	h := NewRedriveHandler(p, WithRedriveTarget("order"))
+
+	msgs := newMessages("a", "b")
+	for i := 0; i < len(msgs); i++ {
+		mp.ExpectSendMessageAndSucceed()
+	}
+	// 空的分区马上就处理完了，但是要等到另外一个分区也处理完才算完成
+	empty := &mockClaim{msgs: make(chan *sarama.ConsumerMessage), initial: sarama.OffsetOldest}
+	session, errs := consumeRedrive(t, h, newMockClaim(msgs...), empty)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, []int64{1, 2}, session.Marked())
+}
+
+func TestRedriveHandler_NothingToRedrive(t *testing.T) {
+	testCases := []struct {
+		name  string
+		claim *mockClaim
+	}{
+		{
+			// 新的消费者组从最新的消息开始，已有的死信都不会处理
+			name:  "从最新的消息开始",
+			claim: &mockClaim{msgs: make(chan *sarama.ConsumerMessage), hwm: 10, initial: sarama.OffsetNewest},
+		},
+		{
+			name:  "空的分区",
+			claim: &mockClaim{msgs: make(chan *sarama.ConsumerMessage), initial: sarama.OffsetOldest},
+		},
+		{
+			name:  "已经处理完了",
+			claim: &mockClaim{msgs: make(chan *sarama.ConsumerMessage), hwm: 10, initial: 10},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mp := mocks.NewSyncProducer(t, nil)
+			p := producer.NewSyncProducerFrom(mp)
+			defer p.Close()
+			// 消息通道没有关闭，没有要处理的死信的时候要马上完成
+			session, errs := consumeRedrive(t, NewRedriveHandler(p), tc.claim)
+			assert.Equal(t, []error{nil}, errs)
+			assert.Empty(t, session.Marked())
+		})
+	}
+
+	// 没有分到分区的消费者也算完成
+	h := NewRedriveHandler(nil)
+	require.NoError(t, h.Setup(newMockSession(context.Background())))
+	select {
+	case <-h.Done():
+	default:
+		t.Fatal("没有分到分区的时候应该已经完成")
+	}
+}
+
+func TestRedrive_NoTarget(t *testing.T) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := producer.NewSyncProducerFrom(mp)
+	defer p.Close()
+	ctx := context.Background()
+
+	// 没有原来的 topic 的时候不能发回死信队列
+	msgs := newMessages("a")
+	msgs[0].Topic = "dlq"
+	assert.Equal(t, ErrNoRedriveTarget, Redrive(ctx, p, msgs[0], ""))
+	h := NewRedriveHandler(p)
+	session, errs := consumeRedrive(t, h, newMockClaim(msgs...))
+	assert.Equal(t, []error{ErrNoRedriveTarget}, errs)
+	assert.Empty(t, session.Marked())
+	select {
+	case <-h.Done():
+		t.Fatal("发送失败的分区没有处理完")
+	default:
+	}
+
+	// 指定了 topic 的时候照样可以发送
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+		assert.Equal(t, "order", pm.Topic)
+		return nil
+	})
+	require.NoError(t, Redrive(ctx, p, msgs[0], "order"))
+}
@@ -0,0 +1,59 @@
+package consumer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+)
+
+// Handler 处理单条消息
+type Handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+// Middleware 给 Handler 加上额外的逻辑
+type Middleware func(next Handler) Handler
+
+// MessageHandler 逐条消费消息的 sarama.ConsumerGroupHandler
+// 处理成功之后才会标记偏移量，处理失败的时候 ConsumeClaim 返回错误，
+// sarama 会因此结束整个会话，所有分区都停止消费，重新加入消费组之后会再次读到这条消息。
+// 会话结束（比如 rebalance）导致的失败不会返回错误，这条消息同样不会被标记。
+// 一直处理失败的消息应该用 Router 转发到重试 topic 或者死信队列
+type MessageHandler struct {
+	fn Handler
+}
+
+// NewMessageHandler 创建逐条消费消息的 sarama.ConsumerGroupHandler，middlewares 按照顺序从外到内包装 fn
+func NewMessageHandler(fn Handler, middlewares ...Middleware) *MessageHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		fn = middlewares[i](fn)
+	}
+	return &MessageHandler{fn: fn}
+}
+
+func (h *MessageHandler) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *MessageHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *MessageHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.fn(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					// 会话已经结束，没有标记的消息会被重新消费，不算失败
+					return nil
+				}
+				return err
+			}
+			session.MarkMessage(msg, "")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMessageHandler_ConsumeClaim(t *testing.T) {
+	bizErr := errors.New("处理失败")
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				order = append(order, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	h := NewMessageHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if string(msg.Value) == "c" {
+			return bizErr
+		}
+		return nil
+	}, middleware("outer"), middleware("inner"))
+
+	claim := newMockClaim(newMessages("a", "b", "c", "d")...)
+	close(claim.msgs)
+	session := newMockSession(context.Background())
+	// 处理失败之后就不再继续，失败的消息不会被标记
+	assert.Equal(t, bizErr, h.ConsumeClaim(session, claim))
+	assert.Equal(t, []int64{1, 2}, session.Marked())
+	assert.Equal(t, []string{"outer", "inner", "outer", "inner", "outer", "inner"}, order)
+}
+
+func TestMessageHandler_SessionDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewMessageHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		// 处理的时候发生了 rebalance
+		cancel()
+		return ctx.Err()
+	})
+
+	claim := newMockClaim(newMessages("a", "b")...)
+	session := newMockSession(ctx)
+	// 会话结束不算失败，但是这条消息不能标记
+	assert.NoError(t, h.ConsumeClaim(session, claim))
+	assert.Empty(t, session.Marked())
+}
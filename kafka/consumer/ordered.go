@@ -0,0 +1,208 @@
+package consumer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/colin-water/go_tool_libaray/base/queue"
+	"golang.org/x/sync/errgroup"
+	"hash/fnv"
+	"sync"
+)
+
+type orderedConfig struct {
+	concurrency int
+	laneSize    int
+	keyFunc     func(msg *sarama.ConsumerMessage) []byte
+}
+
+// OrderedOption OrderedHandler 的可选配置
+type OrderedOption func(c *orderedConfig)
+
+// WithConcurrency 一个分区里面最多有多少个 key 同时被处理，也就是 lane 的数量，默认是 8
+func WithConcurrency(n int) OrderedOption {
+	return func(c *orderedConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithLaneSize 每个 lane 最多缓存多少条还没处理的消息，满了之后会阻塞拉取消息，默认是 64
+func WithLaneSize(n int) OrderedOption {
+	return func(c *orderedConfig) {
+		c.laneSize = n
+	}
+}
+
+// WithKeyFunc 决定消息按照什么分配到 lane 上，默认是消息的 Key
+// 返回 nil 的时候说明这条消息没有顺序要求，会按照偏移量分散到各个 lane 上
+func WithKeyFunc(fn func(msg *sarama.ConsumerMessage) []byte) OrderedOption {
+	return func(c *orderedConfig) {
+		c.keyFunc = fn
+	}
+}
+
+// OrderedHandler 按 key 并发消费消息的 sarama.ConsumerGroupHandler
+// 同一个分区里面的消息按照 key 的哈希分配到固定的 lane 上，每个 lane 由一个 goroutine 逐条处理，
+// 所以相同 key 的消息严格按照偏移量的顺序处理，不同 key 的消息最多 concurrency 个并行处理。
+// 偏移量只会提交到连续处理完的最后一条消息，前面有消息还没处理完的时候，后面处理完的消息也不会被提交。
+// 处理失败的时候 ConsumeClaim 会停止拉取消息并返回错误，sarama 会因此结束整个会话，所有分区都停止消费，
+// 重新加入消费组之后没有提交的消息会再次处理。会话结束（比如 rebalance）导致的失败不会返回错误
+type OrderedHandler struct {
+	fn  Handler
+	cfg orderedConfig
+}
+
+// NewOrderedHandler 创建按 key 并发消费消息的 sarama.ConsumerGroupHandler
+func NewOrderedHandler(fn Handler, opts ...OrderedOption) *OrderedHandler {
+	cfg := orderedConfig{
+		concurrency: 8,
+		laneSize:    64,
+		keyFunc: func(msg *sarama.ConsumerMessage) []byte {
+			return msg.Key
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.concurrency <= 0 {
+		cfg.concurrency = 1
+	}
+	if cfg.laneSize <= 0 {
+		cfg.laneSize = 1
+	}
+	return &OrderedHandler{fn: fn, cfg: cfg}
+}
+
+func (h *OrderedHandler) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *OrderedHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *OrderedHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	eg, ctx := errgroup.WithContext(session.Context())
+	tracker := newOffsetTracker()
+	lanes := make([]*queue.ConcurrentArrayBlockingQueue[*sarama.ConsumerMessage], h.cfg.concurrency)
+	for i := range lanes {
+		lane := queue.NewConcurrentArrayBlockingQueue[*sarama.ConsumerMessage](h.cfg.laneSize)
+		lanes[i] = lane
+		eg.Go(func() error {
+			return h.runLane(ctx, session, tracker, lane)
+		})
+	}
+
+	h.dispatch(ctx, claim, tracker, lanes)
+	return eg.Wait()
+}
+
+// dispatch 把消息分配到各个 lane 上，消费结束之后通知所有的 lane 处理完剩下的消息就退出
+func (h *OrderedHandler) dispatch(ctx context.Context, claim sarama.ConsumerGroupClaim,
+	tracker *offsetTracker, lanes []*queue.ConcurrentArrayBlockingQueue[*sarama.ConsumerMessage]) {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				// nil 是结束的信号，排在已经分配的消息后面
+				for _, lane := range lanes {
+					if lane.Enqueue(ctx, nil) != nil {
+						return
+					}
+				}
+				return
+			}
+			// 先登记再分配，保证偏移量按照拉取的顺序登记
+			tracker.add(msg.Offset)
+			// 队列满了的时候阻塞在这里，不再继续拉取消息
+			if lanes[h.lane(msg)].Enqueue(ctx, msg) != nil {
+				return
+			}
+		case <-ctx.Done():
+			// 会话结束或者有 lane 处理失败，lane 会因为 ctx 结束而退出
+			return
+		}
+	}
+}
+
+// runLane 逐条处理一个 lane 上的消息
+func (h *OrderedHandler) runLane(ctx context.Context, session sarama.ConsumerGroupSession,
+	tracker *offsetTracker, lane *queue.ConcurrentArrayBlockingQueue[*sarama.ConsumerMessage]) error {
+	for {
+		msg, err := lane.Dequeue(ctx)
+		if err != nil || msg == nil {
+			// ctx 结束的时候不算失败，真正的错误由处理失败的 lane 返回
+			return nil
+		}
+		if err = h.fn(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				// 会话已经结束，或者别的 lane 已经失败并且返回了错误，没有提交的消息会被重新消费
+				return nil
+			}
+			return err
+		}
+		tracker.done(msg.Offset, func(offset int64) {
+			// 和 MarkMessage 一样，标记的是下一条要消费的消息的偏移量
+			session.MarkOffset(msg.Topic, msg.Partition, offset+1, "")
+		})
+	}
+}
+
+func (h *OrderedHandler) lane(msg *sarama.ConsumerMessage) int {
+	key := h.cfg.keyFunc(msg)
+	if key == nil {
+		return int(msg.Offset % int64(h.cfg.concurrency))
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	return int(hash.Sum32() % uint32(h.cfg.concurrency))
+}
+
+// offsetTracker 记录一个分区里面已经分配和已经处理完的偏移量，计算可以提交到哪里
+// 偏移量不一定是连续的（比如 compact 之后的 topic），所以不能假设下一条是 offset+1，
+// 只能按照登记的顺序一条条对比
+type offsetTracker struct {
+	mutex sync.Mutex
+	// pending 已经分配但是还没有提交的偏移量
+	pending *queue.PriorityQueue[int64]
+	// finished 已经处理完但是还没有提交的偏移量
+	finished *queue.PriorityQueue[int64]
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		pending:  queue.NewPriorityQueue[int64](0, common.ComparatorRealNumber[int64]),
+		finished: queue.NewPriorityQueue[int64](0, common.ComparatorRealNumber[int64]),
+	}
+}
+
+func (t *offsetTracker) add(offset int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_ = t.pending.Enqueue(offset)
+}
+
+// done 标记 offset 已经处理完，如果可以提交的位置往前推进了，用最后一条连续处理完的偏移量调用 commit
+// commit 在锁里面调用，保证提交的偏移量是递增的
+func (t *offsetTracker) done(offset int64, commit func(offset int64)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_ = t.finished.Enqueue(offset)
+	last := int64(-1)
+	for {
+		p, err := t.pending.Peek()
+		if err != nil {
+			break
+		}
+		f, err := t.finished.Peek()
+		if err != nil || f != p {
+			break
+		}
+		_, _ = t.pending.Dequeue()
+		_, _ = t.finished.Dequeue()
+		last = p
+	}
+	if last >= 0 {
+		commit(last)
+	}
+}
@@ -0,0 +1,168 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newKeyedMessages 创建偏移量从 0 开始的消息，keys[i] 是第 i 条消息的 key
+func newKeyedMessages(keys ...string) []*sarama.ConsumerMessage {
+	msgs := newMessages(keys...)
+	for _, msg := range msgs {
+		msg.Key = msg.Value
+	}
+	return msgs
+}
+
+func TestOrderedHandler_KeyOrder(t *testing.T) {
+	var mutex sync.Mutex
+	got := make(map[string][]int64)
+	h := NewOrderedHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		// 打乱不同 key 的完成顺序
+		time.Sleep(time.Duration(msg.Offset%3) * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		got[string(msg.Key)] = append(got[string(msg.Key)], msg.Offset)
+		return nil
+	}, WithConcurrency(4), WithLaneSize(2))
+
+	claim := newMockClaim(newKeyedMessages("a", "b", "a", "c", "b", "a", "c", "d", "a")...)
+	close(claim.msgs)
+	session := newMockSession(context.Background())
+	require.NoError(t, h.ConsumeClaim(session, claim))
+
+	assert.Equal(t, map[string][]int64{
+		"a": {0, 2, 5, 8},
+		"b": {1, 4},
+		"c": {3, 6},
+		"d": {7},
+	}, got)
+	marked := session.Marked()
+	require.NotEmpty(t, marked)
+	// 提交的偏移量是递增的，最后提交到最后一条消息
+	for i := 1; i < len(marked); i++ {
+		assert.Greater(t, marked[i], marked[i-1])
+	}
+	assert.Equal(t, int64(9), marked[len(marked)-1])
+}
+
+func TestOrderedHandler_Concurrency(t *testing.T) {
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	h := NewOrderedHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(time.Millisecond * 20)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	}, WithConcurrency(2))
+
+	// 没有 key 的消息按照偏移量分散到各个 lane 上
+	claim := newMockClaim(newMessages("a", "b", "c", "d", "e", "f")...)
+	close(claim.msgs)
+	session := newMockSession(context.Background())
+	require.NoError(t, h.ConsumeClaim(session, claim))
+	assert.Equal(t, 2, maxRunning)
+	marked := session.Marked()
+	assert.Equal(t, int64(6), marked[len(marked)-1])
+}
+
+func TestOrderedHandler_LowestOffset(t *testing.T) {
+	release := make(chan struct{})
+	h := NewOrderedHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		// key 是 slow 的第一条消息卡住，后面其它 key 的消息先处理完
+		if string(msg.Key) == "slow" {
+			<-release
+		}
+		return nil
+	}, WithConcurrency(4))
+
+	msgs := newKeyedMessages("fast", "slow", "fast", "other", "fast")
+	claim := newMockClaim(msgs...)
+	session := newMockSession(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.ConsumeClaim(session, claim)
+	}()
+
+	// 偏移量 0 处理完之后只能提交到 1，后面的消息处理完了也不能越过 slow
+	require.Eventually(t, func() bool {
+		return len(session.Marked()) > 0
+	}, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, []int64{1}, session.Marked())
+
+	close(release)
+	close(claim.msgs)
+	require.NoError(t, <-done)
+	assert.Equal(t, []int64{1, 5}, session.Marked())
+}
+
+func TestOrderedHandler_Error(t *testing.T) {
+	errBiz := errors.New("biz error")
+	h := NewOrderedHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			return errBiz
+		}
+		return nil
+	}, WithConcurrency(1))
+
+	claim := newMockClaim(newKeyedMessages("a", "a", "a", "a")...)
+	session := newMockSession(context.Background())
+	// 出错之后不需要等到消息通道关闭
+	assert.Equal(t, errBiz, h.ConsumeClaim(session, claim))
+	marked := session.Marked()
+	require.NotEmpty(t, marked)
+	assert.Equal(t, int64(2), marked[len(marked)-1])
+}
+
+func TestOrderedHandler_SessionDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewOrderedHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			// 处理的时候发生了 rebalance
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}, WithConcurrency(1))
+
+	claim := newMockClaim(newKeyedMessages("a", "a", "a", "a")...)
+	session := newMockSession(ctx)
+	// 会话结束不算失败，被打断的消息和后面的消息都不能提交
+	require.NoError(t, h.ConsumeClaim(session, claim))
+	marked := session.Marked()
+	require.NotEmpty(t, marked)
+	assert.Equal(t, int64(2), marked[len(marked)-1])
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	// 偏移量可能不连续
+	for _, offset := range []int64{3, 5, 6, 9} {
+		tracker.add(offset)
+	}
+	var committed []int64
+	commit := func(offset int64) {
+		committed = append(committed, offset)
+	}
+	tracker.done(6, commit)
+	tracker.done(5, commit)
+	assert.Empty(t, committed)
+	tracker.done(3, commit)
+	assert.Equal(t, []int64{6}, committed)
+	tracker.done(9, commit)
+	assert.Equal(t, []int64{6, 9}, committed)
+}
@@ -0,0 +1,166 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/colin-water/go_tool_libaray/kafka/producer"
+	"sync"
+)
+
+// ErrNoRedriveTarget 死信没有记录原来的 topic，又没有通过 WithRedriveTarget 指定要发送到哪里
+var ErrNoRedriveTarget = errors.New("consumer: 死信没有原来的 topic，需要指定重新发送的 topic")
+
+// RedriveHandler 把死信队列里面的消息重新发送回原来的 topic 的 sarama.ConsumerGroupHandler
+// 重新发送的消息会去掉失败次数之类的 header，从头开始处理。
+// 只会处理开始消费的时候分区里面已经有的消息，不会一直等新的死信。
+// 一个分区处理完之后 ConsumeClaim 也不会返回，因为它一返回 sarama 就会结束整个会话，其它分区也会被打断，
+// 而是等到分到的所有分区都处理完之后关闭 Done，调用方收到之后再结束消费。
+// 使用的消费者组第一次消费的时候必须从最早的消息开始，也就是 Consumer.Offsets.Initial 设置成 sarama.OffsetOldest，
+// 否则没有提交过偏移量的分区一条已有的死信都不会处理
+type RedriveHandler struct {
+	producer *producer.Producer
+	// target 重新发送到哪个 topic，为空的时候发送到消息原来的 topic
+	target string
+	filter func(msg *sarama.ConsumerMessage) bool
+
+	mutex sync.Mutex
+	// pending 这一次会话分到的分区里面还没有处理完的数量
+	pending  int
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// RedriveOption RedriveHandler 的可选配置
+type RedriveOption func(h *RedriveHandler)
+
+// WithRedriveTarget 重新发送到指定的 topic，默认发送到消息原来的 topic
+func WithRedriveTarget(topic string) RedriveOption {
+	return func(h *RedriveHandler) {
+		h.target = topic
+	}
+}
+
+// WithRedriveFilter 只重新发送 filter 返回 true 的消息，其它的消息会被跳过
+func WithRedriveFilter(filter func(msg *sarama.ConsumerMessage) bool) RedriveOption {
+	return func(h *RedriveHandler) {
+		h.filter = filter
+	}
+}
+
+// NewRedriveHandler 创建重新发送死信的 sarama.ConsumerGroupHandler
+// producer 必须使用同步模式，使用异步模式的时候 ConsumeClaim 会返回 ErrAsyncProducer
+func NewRedriveHandler(p *producer.Producer, opts ...RedriveOption) *RedriveHandler {
+	h := &RedriveHandler{
+		producer: p,
+		done:     make(chan struct{}),
+		filter: func(msg *sarama.ConsumerMessage) bool {
+			return true
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Done 分到的所有分区都处理完的时候被关闭
+// 只代表这个消费者分到的分区，消费者组里面有多个消费者的时候，每个消费者都要等到自己的 Done。
+// 处理到一半发生 rebalance 的时候，按照新分到的分区重新计算，已经处理完的分区会马上完成。
+// 关闭之后不会再打开，每次重新发送死信都要创建新的 RedriveHandler
+func (h *RedriveHandler) Done() <-chan struct{} {
+	return h.done
+}
+
+func (h *RedriveHandler) Setup(session sarama.ConsumerGroupSession) error {
+	pending := 0
+	for _, partitions := range session.Claims() {
+		pending += len(partitions)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.pending = pending
+	if pending == 0 {
+		h.finish()
+	}
+	return nil
+}
+
+func (h *RedriveHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *RedriveHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	if err := h.redrive(ctx, session, claim); err != nil {
+		return err
+	}
+	// 提前返回会结束整个会话，所以处理完了也要等到会话结束
+	<-ctx.Done()
+	return nil
+}
+
+// redrive 重新发送分区里面已有的死信，处理完之后标记这个分区已经完成
+func (h *RedriveHandler) redrive(ctx context.Context, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// 开始消费的时候分区里面最后一条消息的下一个偏移量
+	end := claim.HighWaterMarkOffset()
+	// 没有提交过偏移量的时候 InitialOffset 是 Consumer.Offsets.Initial，
+	// 是 OffsetNewest 说明只会消费新的死信，已有的死信都不会处理
+	initial := claim.InitialOffset()
+	if initial == sarama.OffsetNewest || end == 0 || end <= initial {
+		h.claimDone()
+		return nil
+	}
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if h.filter(msg) {
+				if err := Redrive(ctx, h.producer, msg, h.target); err != nil {
+					return err
+				}
+			}
+			session.MarkMessage(msg, "")
+			if msg.Offset+1 >= end {
+				h.claimDone()
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// claimDone 一个分区处理完了，所有分区都处理完的时候关闭 done
+func (h *RedriveHandler) claimDone() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.pending--
+	if h.pending <= 0 {
+		h.finish()
+	}
+}
+
+func (h *RedriveHandler) finish() {
+	h.doneOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// Redrive 把死信重新发送到 topic，topic 为空的时候发送到消息原来的 topic
+// topic 为空并且消息没有记录原来的 topic 的时候返回 ErrNoRedriveTarget，不会再发回死信队列
+func Redrive(ctx context.Context, p *producer.Producer, msg *sarama.ConsumerMessage, topic string) error {
+	if topic == "" {
+		original, ok := header(msg.Headers, HeaderOriginalTopic)
+		if !ok || original == "" {
+			return ErrNoRedriveTarget
+		}
+		topic = original
+	}
+	return forward(ctx, p, msg, topic, nil, []string{
+		HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset,
+		HeaderError, HeaderAttempt, HeaderNotBefore,
+	})
+}
@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"sync"
+)
+
+// mockSession 记录被标记的消息
+type mockSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	mutex  sync.Mutex
+	marked []int64
+}
+
+func newMockSession(ctx context.Context) *mockSession {
+	return &mockSession{ctx: ctx}
+}
+
+func (s *mockSession) Claims() map[string][]int32 { return s.claims }
+func (s *mockSession) MemberID() string           { return "member" }
+func (s *mockSession) GenerationID() int32        { return 1 }
+func (s *mockSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.marked = append(s.marked, offset)
+}
+func (s *mockSession) Commit() {}
+func (s *mockSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (s *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	// 和 sarama 一样，标记的是下一条要消费的消息的偏移量
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+func (s *mockSession) Context() context.Context { return s.ctx }
+
+// Marked 返回所有被标记的偏移量
+func (s *mockSession) Marked() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+// mockClaim 消息来自 msgs
+type mockClaim struct {
+	msgs    chan *sarama.ConsumerMessage
+	hwm     int64
+	initial int64
+}
+
+func newMockClaim(msgs ...*sarama.ConsumerMessage) *mockClaim {
+	ch := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, msg := range msgs {
+		ch <- msg
+	}
+	hwm := int64(0)
+	if len(msgs) > 0 {
+		hwm = msgs[len(msgs)-1].Offset + 1
+	}
+	return &mockClaim{msgs: ch, hwm: hwm}
+}
+
+func (c *mockClaim) Topic() string                            { return "test_topic" }
+func (c *mockClaim) Partition() int32                         { return 0 }
+func (c *mockClaim) InitialOffset() int64                     { return c.initial }
+func (c *mockClaim) HighWaterMarkOffset() int64               { return c.hwm }
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+// newMessages 创建偏移量从 0 开始的消息
+func newMessages(values ...string) []*sarama.ConsumerMessage {
+	msgs := make([]*sarama.ConsumerMessage, 0, len(values))
+	for i, val := range values {
+		msgs = append(msgs, &sarama.ConsumerMessage{
+			Topic:  "test_topic",
+			Offset: int64(i),
+			Value:  []byte(val),
+		})
+	}
+	return msgs
+}
+
+var (
+	_ sarama.ConsumerGroupSession = &mockSession{}
+	_ sarama.ConsumerGroupClaim   = &mockClaim{}
+)
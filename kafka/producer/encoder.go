@@ -0,0 +1,50 @@
+package producer
+
+import "encoding/json"
+
+// Encoder 把消息编码成字节
+type Encoder[T any] interface {
+	Encode(v T) ([]byte, error)
+}
+
+// EncoderFunc 让普通的函数也能作为 Encoder
+// 比如 google.golang.org/protobuf 的消息可以用 EncoderFunc[*pb.Msg](func(m *pb.Msg) ([]byte, error) { return proto.Marshal(m) })
+type EncoderFunc[T any] func(v T) ([]byte, error)
+
+func (f EncoderFunc[T]) Encode(v T) ([]byte, error) {
+	return f(v)
+}
+
+// JSONEncoder 用 JSON 编码
+type JSONEncoder[T any] struct{}
+
+func (JSONEncoder[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// RawEncoder 消息本身就是字节，不需要编码
+type RawEncoder struct{}
+
+func (RawEncoder) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// StringEncoder 消息是字符串
+type StringEncoder struct{}
+
+func (StringEncoder) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+// ProtoMarshaler 能够把自己编码成 protobuf 字节的消息，
+// gogo/protobuf 和 vtprotobuf 生成的代码都有 Marshal 方法
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoEncoder 用消息自己的 Marshal 方法编码成 protobuf 字节
+type ProtoEncoder[T ProtoMarshaler] struct{}
+
+func (ProtoEncoder[T]) Encode(v T) ([]byte, error) {
+	return v.Marshal()
+}
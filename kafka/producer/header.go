@@ -0,0 +1,45 @@
+package producer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+)
+
+// TraceIDHeader 默认的 trace id 的 header
+const TraceIDHeader = "trace_id"
+
+type traceIDKey struct{}
+
+// WithTraceID 把 trace id 放到 ctx 里面，发送消息的时候会被放到 header 里面
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID 从 ctx 里面拿到 trace id
+func TraceID(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(traceIDKey{}).(string)
+	return traceID, ok && traceID != ""
+}
+
+// ContextWithHeaders 从消费到的消息的 header 里面拿到 trace id 放到 ctx 里面，
+// 这样消费者处理消息的时候再发消息，trace id 就能继续传递下去
+func ContextWithHeaders(ctx context.Context, headers []*sarama.RecordHeader) context.Context {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == TraceIDHeader {
+			return WithTraceID(ctx, string(h.Value))
+		}
+	}
+	return ctx
+}
+
+// HeaderFunc 根据 ctx 生成要放到消息里面的 header
+type HeaderFunc func(ctx context.Context) []sarama.RecordHeader
+
+// traceIDHeader 把 ctx 里面的 trace id 放到 header 里面
+func traceIDHeader(ctx context.Context) []sarama.RecordHeader {
+	traceID, ok := TraceID(ctx)
+	if !ok {
+		return nil
+	}
+	return []sarama.RecordHeader{{Key: []byte(TraceIDHeader), Value: []byte(traceID)}}
+}
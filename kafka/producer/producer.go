@@ -0,0 +1,244 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"log"
+	"sync"
+)
+
+var ErrClosed = errors.New("producer: 生产者已经关闭")
+
+// Producer 对 sarama 生产者的封装
+// 同步模式下 SendMessage 会等到 kafka 确认才返回；
+// 异步模式下 SendMessage 把消息交给 sarama 就返回，最多有 maxInflight 条消息在等待确认，
+// 超过之后 SendMessage 会阻塞，发送失败的消息交给 ErrorHandler 处理
+type Producer struct {
+	sync  sarama.SyncProducer
+	async sarama.AsyncProducer
+
+	headers      []HeaderFunc
+	errorHandler func(msg *sarama.ProducerMessage, err error)
+	maxInflight  int
+
+	// inflight 异步模式下在等待确认的消息，相当于一个信号量
+	inflight chan struct{}
+	// mutex 保护 closed，发送的时候拿读锁，关闭的时候拿写锁
+	mutex  sync.RWMutex
+	closed bool
+	// done 异步模式下处理确认结果的 goroutine 退出之后关闭
+	done chan struct{}
+}
+
+// Option 生产者的可选配置
+type Option func(p *Producer)
+
+// WithHeaders 发送消息的时候根据 ctx 添加 header，ctx 里面的 trace id 默认就会被添加
+func WithHeaders(fn HeaderFunc) Option {
+	return func(p *Producer) {
+		p.headers = append(p.headers, fn)
+	}
+}
+
+// WithErrorHandler 异步模式下发送失败的时候回调，默认打印日志
+func WithErrorHandler(fn func(msg *sarama.ProducerMessage, err error)) Option {
+	return func(p *Producer) {
+		p.errorHandler = fn
+	}
+}
+
+// WithMaxInflight 异步模式下最多有多少条消息在等待确认，默认是 1024
+func WithMaxInflight(n int) Option {
+	return func(p *Producer) {
+		p.maxInflight = n
+	}
+}
+
+// NewSyncProducer 创建同步模式的生产者，不会修改传入的 cfg
+func NewSyncProducer(addrs []string, cfg *sarama.Config, opts ...Option) (*Producer, error) {
+	cfg = copyConfig(cfg)
+	// 同步生产者要求返回成功的信息
+	cfg.Producer.Return.Successes = true
+	p, err := sarama.NewSyncProducer(addrs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewSyncProducerFrom(p, opts...), nil
+}
+
+// NewSyncProducerFrom 使用已有的 sarama.SyncProducer 创建同步模式的生产者
+func NewSyncProducerFrom(p sarama.SyncProducer, opts ...Option) *Producer {
+	return newProducer(opts, func(res *Producer) {
+		res.sync = p
+	})
+}
+
+// NewAsyncProducer 创建异步模式的生产者，不会修改传入的 cfg
+func NewAsyncProducer(addrs []string, cfg *sarama.Config, opts ...Option) (*Producer, error) {
+	cfg = copyConfig(cfg)
+	// 要靠成功和失败的信息来释放在等待确认的位置
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	p, err := sarama.NewAsyncProducer(addrs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewAsyncProducerFrom(p, opts...), nil
+}
+
+// NewAsyncProducerFrom 使用已有的 sarama.AsyncProducer 创建异步模式的生产者
+// p 的配置里面 Producer.Return.Successes 和 Producer.Return.Errors 都必须是 true
+func NewAsyncProducerFrom(p sarama.AsyncProducer, opts ...Option) *Producer {
+	res := newProducer(opts, func(res *Producer) {
+		res.async = p
+	})
+	res.inflight = make(chan struct{}, res.maxInflight)
+	res.done = make(chan struct{})
+	go res.handleResults()
+	return res
+}
+
+// copyConfig 复制一份配置，cfg 为 nil 的时候使用 sarama 的默认配置
+func copyConfig(cfg *sarama.Config) *sarama.Config {
+	if cfg == nil {
+		return sarama.NewConfig()
+	}
+	res := *cfg
+	return &res
+}
+
+func newProducer(opts []Option, init func(p *Producer)) *Producer {
+	p := &Producer{
+		headers:     []HeaderFunc{traceIDHeader},
+		maxInflight: 1024,
+		errorHandler: func(msg *sarama.ProducerMessage, err error) {
+			log.Println("producer: 发送消息失败", msg.Topic, err)
+		},
+	}
+	init(p)
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.maxInflight <= 0 {
+		p.maxInflight = 1
+	}
+	return p
+}
+
+// Send 编码之后发送消息，key 为空的时候不设置消息的 key
+func Send[T any](ctx context.Context, p *Producer, enc Encoder[T], topic string, key string, v T) error {
+	data, err := enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(data),
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return p.SendMessage(ctx, msg)
+}
+
+// IsSync 是否是同步模式的生产者
+// 只有同步模式下 SendMessage 返回 nil 才代表 kafka 已经确认收到了消息
+func (p *Producer) IsSync() bool {
+	return p.sync != nil
+}
+
+// SendMessage 发送消息，会根据 ctx 添加 header
+// header 加在 msg 的副本上，同一条消息重复发送不会带上重复的 header。
+// 同步模式下发送成功之后 msg 的 Partition 和 Offset 会被设置；
+// 异步模式下返回 nil 只代表消息已经交给了 sarama，ErrorHandler 拿到的是副本
+func (p *Producer) SendMessage(ctx context.Context, msg *sarama.ProducerMessage) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	m := p.withHeaders(ctx, msg)
+	if p.sync != nil {
+		// sarama 的同步发送不支持 ctx，至少不要在 ctx 已经结束的时候再发
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		partition, offset, err := p.sync.SendMessage(m)
+		if err == nil {
+			msg.Partition, msg.Offset = partition, offset
+		}
+		return err
+	}
+
+	// 等待确认的消息太多了，等一个位置出来
+	select {
+	case p.inflight <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.async.Input() <- m
+	return nil
+}
+
+// withHeaders 复制 msg 并且加上根据 ctx 生成的 header
+// 只复制调用者可以设置的字段，sarama 内部的状态不复制
+func (p *Producer) withHeaders(ctx context.Context, msg *sarama.ProducerMessage) *sarama.ProducerMessage {
+	headers := make([]sarama.RecordHeader, len(msg.Headers), len(msg.Headers)+len(p.headers))
+	copy(headers, msg.Headers)
+	for _, fn := range p.headers {
+		headers = append(headers, fn(ctx)...)
+	}
+	return &sarama.ProducerMessage{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Metadata:  msg.Metadata,
+		Partition: msg.Partition,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// handleResults 处理异步发送的结果，释放在等待确认的位置
+func (p *Producer) handleResults() {
+	defer close(p.done)
+	successes, errs := p.async.Successes(), p.async.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case _, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			<-p.inflight
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			<-p.inflight
+			p.errorHandler(perr.Msg, perr.Err)
+		}
+	}
+}
+
+// Close 关闭生产者，异步模式下会等到所有已经交给 sarama 的消息都发送完毕
+// 关闭之后再发送消息会返回 ErrClosed
+func (p *Producer) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mutex.Unlock()
+
+	if p.sync != nil {
+		return p.sync.Close()
+	}
+	// AsyncClose 会把缓冲的消息都发送出去，然后关闭 Successes 和 Errors
+	p.async.AsyncClose()
+	<-p.done
+	return nil
+}
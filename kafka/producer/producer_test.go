@@ -0,0 +1,172 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+type article struct {
+	Aid int64 `json:"aid"`
+	Uid int64 `json:"uid"`
+}
+
+// protoArticle 模拟 protobuf 生成的代码
+type protoArticle struct {
+	data []byte
+}
+
+func (p protoArticle) Marshal() ([]byte, error) {
+	return p.data, nil
+}
+
+func TestSend_Sync(t *testing.T) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := NewSyncProducerFrom(mp)
+	assert.True(t, p.IsSync())
+	ctx := WithTraceID(context.Background(), "trace-123")
+
+	mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "read_article", msg.Topic)
+		key, _ := msg.Key.Encode()
+		assert.Equal(t, "aid-1", string(key))
+		val, _ := msg.Value.Encode()
+		assert.JSONEq(t, `{"aid": 1, "uid": 123}`, string(val))
+		require.Len(t, msg.Headers, 1)
+		assert.Equal(t, TraceIDHeader, string(msg.Headers[0].Key))
+		assert.Equal(t, "trace-123", string(msg.Headers[0].Value))
+		return nil
+	})
+	err := Send[article](ctx, p, JSONEncoder[article]{}, "read_article", "aid-1", article{Aid: 1, Uid: 123})
+	require.NoError(t, err)
+
+	mp.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		assert.Equal(t, "raw", string(val))
+		return nil
+	})
+	require.NoError(t, Send[[]byte](ctx, p, RawEncoder{}, "raw_topic", "", []byte("raw")))
+
+	mp.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		assert.Equal(t, "proto", string(val))
+		return nil
+	})
+	require.NoError(t, Send[protoArticle](ctx, p, ProtoEncoder[protoArticle]{}, "pb_topic", "", protoArticle{data: []byte("proto")}))
+
+	sendErr := errors.New("发送失败")
+	mp.ExpectSendMessageAndFail(sendErr)
+	err = Send[string](ctx, p, StringEncoder{}, "str_topic", "", "hello")
+	assert.Equal(t, sendErr, err)
+
+	require.NoError(t, p.Close())
+	assert.Equal(t, ErrClosed, Send[string](ctx, p, StringEncoder{}, "str_topic", "", "hello"))
+}
+
+func TestSend_Async(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	mp := mocks.NewAsyncProducer(t, cfg)
+
+	var mutex sync.Mutex
+	var failed []string
+	p := NewAsyncProducerFrom(mp, WithMaxInflight(2),
+		WithErrorHandler(func(msg *sarama.ProducerMessage, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			val, _ := msg.Value.Encode()
+			failed = append(failed, string(val))
+		}),
+		WithHeaders(func(ctx context.Context) []sarama.RecordHeader {
+			return []sarama.RecordHeader{{Key: []byte("app"), Value: []byte("test")}}
+		}))
+	assert.False(t, p.IsSync())
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		if i == 3 {
+			mp.ExpectInputAndFail(errors.New("发送失败"))
+			continue
+		}
+		mp.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			require.Len(t, msg.Headers, 1)
+			assert.Equal(t, "app", string(msg.Headers[0].Key))
+			return nil
+		})
+	}
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		require.NoError(t, Send[string](ctx, p, StringEncoder{}, "test_topic", "", string(rune('a'+i))))
+	}
+	// Close 会等到所有消息都发送完毕，mock 会检查所有的预期都满足了
+	require.NoError(t, p.Close())
+	assert.Equal(t, []string{"d"}, failed)
+	assert.Equal(t, 0, len(p.inflight))
+	assert.Equal(t, ErrClosed, Send[string](ctx, p, StringEncoder{}, "test_topic", "", "a"))
+}
+
+func TestSend_AsyncInflightWindow(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	mp := mocks.NewAsyncProducer(t, cfg)
+	// 没有确认的时候，窗口满了就会阻塞
+	p := NewAsyncProducerFrom(mp, WithMaxInflight(1))
+	p.inflight <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Send[string](ctx, p, StringEncoder{}, "test_topic", "", "a")
+	assert.Equal(t, context.Canceled, err)
+
+	<-p.inflight
+	require.NoError(t, p.Close())
+}
+
+func TestSendMessage_Resend(t *testing.T) {
+	mp := mocks.NewSyncProducer(t, nil)
+	p := NewSyncProducerFrom(mp)
+	ctx := WithTraceID(context.Background(), "trace-123")
+
+	// 重复发送同一条消息，header 不会重复
+	msg := &sarama.ProducerMessage{
+		Topic:   "test_topic",
+		Value:   sarama.StringEncoder("a"),
+		Headers: []sarama.RecordHeader{{Key: []byte("app"), Value: []byte("test")}},
+	}
+	for i := 0; i < 2; i++ {
+		mp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(m *sarama.ProducerMessage) error {
+			require.Len(t, m.Headers, 2)
+			assert.Equal(t, "app", string(m.Headers[0].Key))
+			assert.Equal(t, TraceIDHeader, string(m.Headers[1].Key))
+			return nil
+		})
+		require.NoError(t, p.SendMessage(ctx, msg))
+		assert.Len(t, msg.Headers, 1)
+	}
+
+	// ctx 已经结束的时候不发送
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, p.SendMessage(cancelCtx, msg))
+
+	// 关闭之后不会修改消息
+	require.NoError(t, p.Close())
+	assert.Equal(t, ErrClosed, p.SendMessage(ctx, msg))
+	assert.Len(t, msg.Headers, 1)
+}
+
+func TestNewSyncProducer_Config(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Metadata.Retry.Max = 0
+	// 连不上 kafka 也没关系，只检查传入的配置没有被修改
+	_, err := NewSyncProducer([]string{"127.0.0.1:1"}, cfg)
+	assert.Error(t, err)
+	_, err = NewAsyncProducer([]string{"127.0.0.1:1"}, cfg)
+	assert.Error(t, err)
+	assert.False(t, cfg.Producer.Return.Successes)
+}
@@ -78,6 +78,9 @@ func (t testConsumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) e
 //处理完毕后，标记最新的消息为已处理。
 //整个处理过程通过上下文的超时控制，以及 errgroup 的协程组方式，
 //实现了批量且有超时控制的消息处理。
+// 这里只是示例，出错的时候这一批消息既不会重试也不会重新处理，
+// 实际使用的时候用 kafka/consumer 里面的 BatchHandler，
+// 需要保证相同 key 的消息按顺序处理的时候用 OrderedHandler
 func (t testConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	// 获取分配给当前消费者组的消息通道
 	msgs := claim.Messages()
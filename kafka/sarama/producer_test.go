@@ -106,8 +106,3 @@ func TestAsyncProducer(t *testing.T) {
 		}
 	}
 }
-
-// 自定义 JSON 编码器
-type JSONEncoder struct {
-	Data any
-}
@@ -0,0 +1,158 @@
+package redis_lock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟，MemoryLocker 通过它来判断锁是否过期，
+// 它返回的 Lock 也通过它来判断什么时候丢失
+type Clock interface {
+	Now() time.Time
+	// After 和 time.After 一样
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc 和 time.AfterFunc 一样
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer AfterFunc 返回的定时器，用法和 *time.Timer 一样
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock 手动推进的时钟，用于测试
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	timers  []*fakeTimer
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now: now,
+	}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// After 在时钟被推进到 now + d 的时候触发
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, fakeWaiter{deadline: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// AfterFunc 在时钟被推进到 now + d 的时候执行 f
+// 和 time.AfterFunc 不一样，f 是在 Advance（d 小于等于 0 的时候是 AfterFunc 或者 Reset）返回之前同步执行的，
+// 测试里面推进时钟之后马上就能看到结果
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance 把时钟往后推进 d，到期的 After 和 AfterFunc 都会被触发
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if !f.now.Before(w.deadline) {
+			w.ch <- f.now
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	f.waiters = waiters
+	var fired []func()
+	timers := f.timers[:0]
+	for _, t := range f.timers {
+		if !f.now.Before(t.deadline) {
+			t.active = false
+			fired = append(fired, t.fn)
+			continue
+		}
+		timers = append(timers, t)
+	}
+	f.timers = timers
+	f.mutex.Unlock()
+	// 不能持有锁，f 里面可能会再用到时钟
+	for _, fn := range fired {
+		fn()
+	}
+}
+
+// fakeTimer FakeClock.AfterFunc 返回的定时器，字段都由 clock 的锁保护
+type fakeTimer struct {
+	clock    *FakeClock
+	fn       func()
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	return t.stop()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	active := t.stop()
+	if d <= 0 {
+		t.clock.mutex.Unlock()
+		t.fn()
+		return active
+	}
+	t.deadline = t.clock.now.Add(d)
+	t.active = true
+	t.clock.timers = append(t.clock.timers, t)
+	t.clock.mutex.Unlock()
+	return active
+}
+
+// stop 需要持有 clock 的锁
+func (t *fakeTimer) stop() bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, other := range t.clock.timers {
+		if other == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package redis_lock
+
+import (
+	"context"
+	"time"
+)
+
+// 公平锁在 redis 里面除了锁本身和 fencing token 计数器，还用到了三个 key，
+// 都带上了 hash tag，和锁本身在 redis cluster 里面落在同一个 slot：
+// {<key>}:queue 等待队列，score 是排队的序号
+// {<key>}:queue:heartbeat 等待者的心跳，score 是心跳的过期时间
+// {<key>}:queue:seq 排队序号的计数器
+func fairKeys(key string) []string {
+	queue := hashTag(key) + ":queue"
+	return []string{key, fencingKey(key), queue, queue + ":heartbeat", queue + ":seq"}
+}
+
+// defaultFairHeartbeat 公平锁等待者默认的心跳过期时间
+const defaultFairHeartbeat = time.Second * 3
+
+// WithFairHeartbeat 设置公平锁等待者的心跳过期时间，和锁的过期时间、重试间隔都没有关系
+// 等待的时候每隔三分之一的 ttl 刷新一次心跳，挂掉的等待者最多挡住后面的人 ttl 这么久
+func WithFairHeartbeat(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		if ttl > 0 {
+			c.fairHeartbeat = ttl
+		}
+	}
+}
+
+// FairLock 以公平模式获取锁：等待者按照先来后到排队，只有队头才能拿到锁
+// 等待的过程中会在后台定期刷新等待者的心跳，过期时间参考 WithFairHeartbeat，
+// 心跳过期的等待者会被当成已经挂了，踢出队列。所以重试间隔比心跳长也不会丢掉排队的位置。
+// 锁被释放的时候会通过 pub/sub 唤醒等待者，重试策略只是兜底，防止通知丢失。
+// 拿到的锁和 Lock 拿到的锁是一样的，正常 Refresh 和 Unlock 就可以。
+// 注意：同一个 key 上的 Lock 和 TryLock 不会排队，要公平就要所有人都用 FairLock
+func (c *Client) FairLock(ctx context.Context, key string, expiration time.Duration,
+	timeout time.Duration, retry RetryStrategy) (*Lock, error) {
+	val := newLockValues(ctx)
+	// 排队用的是 value 里面的 id，等待的过程中 value 会变，id 不会
+	id := val.info.ID
+	keys := fairKeys(key)
+	var token int64
+	var acquiredAt time.Time
+	heartbeat := c.fairHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultFairHeartbeat
+	}
+	start := time.Now()
+	stopHeartbeat := c.fairHeartbeatLoop(ctx, keys, id, heartbeat)
+	err := retryLockWithWakeup(ctx, timeout, c.observeRetry(ctx, key, retry), c.unlockSubscriber(key), func(ctx context.Context) (bool, error) {
+		var err error
+		acquiredAt = c.clock.Now()
+		attemptStart := time.Now()
+		token, err = val.attempt(func(val string) (int64, error) {
+			return c.client.Eval(ctx, luaFairLock, keys, val, expiration.Milliseconds(), heartbeat.Milliseconds(), id).Int64()
+		})
+		c.observeAttempt(ctx, key, time.Since(attemptStart), token > 0, err)
+		return token > 0, err
+	})
+	stopHeartbeat()
+	if err != nil {
+		// 放弃排队，不然后面的人要等到心跳过期才能轮到
+		cleanup(timeout, func(ctx context.Context) {
+			c.client.Eval(ctx, luaFairCancel, keys[2:4], id, unlockChannel(key))
+		})
+		return nil, err
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newLock(c, c.clock, key, val.val, expiration, token, acquiredAt), nil
+}
+
+// fairHeartbeatLoop 在后台每隔 ttl / 3 刷新一次等待者的心跳，直到返回的函数被调用
+// 刷新失败不要紧，下一次刷新或者下一次尝试加锁还会再刷新
+func (c *Client) fairHeartbeatLoop(ctx context.Context, keys []string, id string, ttl time.Duration) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		interval := ttl / 3
+		if interval <= 0 {
+			interval = ttl
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hctx, hcancel := context.WithTimeout(ctx, interval)
+				c.client.Eval(hctx, luaFairHeartbeat, []string{keys[2], keys[3], keys[4]}, id, ttl.Milliseconds())
+				hcancel()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		// 等后台的刷新结束，不然放弃排队之后可能又被刷新一次心跳
+		<-done
+	}
+}
@@ -0,0 +1,144 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFairLock_Keys(t *testing.T) {
+	assert.Equal(t, []string{"key1", "{key1}:fencing", "{key1}:queue", "{key1}:queue:heartbeat", "{key1}:queue:seq"},
+		fairKeys("key1"))
+}
+
+func TestFairLock_FIFO(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+	queueKey := fairKeys("key1")[2]
+	retry := &FixedIntervalRetryStrategy{Interval: time.Millisecond * 10, MaxCnt: 200}
+
+	holder, err := c.FairLock(ctx, "key1", time.Second*10, time.Second, retry)
+	require.NoError(t, err)
+
+	// waiter 按照排队的顺序拿到锁
+	acquired := make(chan string, 2)
+	locks := make(chan *Lock, 2)
+	waitQueue := func(n int) {
+		require.Eventually(t, func() bool {
+			members, _ := mr.ZMembers(queueKey)
+			return len(members) == n
+		}, time.Second, time.Millisecond)
+	}
+	for i, name := range []string{"first", "second"} {
+		name := name
+		go func() {
+			l, err := c.FairLock(ctx, "key1", time.Second*10, time.Second, retry)
+			if assert.NoError(t, err) {
+				acquired <- name
+				locks <- l
+			}
+		}()
+		waitQueue(i + 1)
+	}
+
+	require.NoError(t, holder.Unlock(ctx))
+	assert.Equal(t, "first", <-acquired)
+	l := <-locks
+	// 第一个人还拿着锁，第二个人不能插队
+	select {
+	case name := <-acquired:
+		t.Fatalf("%s 不应该拿到锁", name)
+	case <-time.After(time.Millisecond * 50):
+	}
+	require.NoError(t, l.Unlock(ctx))
+	assert.Equal(t, "second", <-acquired)
+	require.NoError(t, (<-locks).Unlock(ctx))
+	members, _ := mr.ZMembers(queueKey)
+	assert.Empty(t, members)
+}
+
+func TestFairLock_HeartbeatExpired(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+	keys := fairKeys("key1")
+
+	// 排在前面的等待者已经挂了，心跳过期之后被踢出队列，不会一直挡住后面的人
+	_, err := mr.ZAdd(keys[2], 1, "dead")
+	require.NoError(t, err)
+	_, err = mr.ZAdd(keys[3], float64(time.Now().Add(-time.Second).UnixMilli()), "dead")
+	require.NoError(t, err)
+	l, err := c.FairLock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+	members, _ := mr.ZMembers(keys[2])
+	assert.Empty(t, members)
+	require.NoError(t, l.Unlock(ctx))
+}
+
+func TestFairLock_RetryLongerThanHeartbeat(t *testing.T) {
+	c, mr := newClientForTest(t, WithFairHeartbeat(time.Millisecond*100))
+	ctx := context.Background()
+	keys := fairKeys("key1")
+
+	_, err := c.FairLock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+
+	// 先来的人重试间隔比心跳和锁的过期时间都长，后来的人一直在重试
+	acquired := make(chan string, 2)
+	first := func() {
+		l, err := c.FairLock(ctx, "key1", time.Millisecond*50, time.Second,
+			&FixedIntervalRetryStrategy{Interval: time.Millisecond * 500, MaxCnt: 3})
+		if assert.NoError(t, err) {
+			assert.NoError(t, l.Unlock(ctx))
+			acquired <- "first"
+		}
+	}
+	second := func() {
+		l, err := c.FairLock(ctx, "key1", time.Millisecond*50, time.Second,
+			&FixedIntervalRetryStrategy{Interval: time.Millisecond * 10, MaxCnt: 200})
+		if assert.NoError(t, err) {
+			assert.NoError(t, l.Unlock(ctx))
+			acquired <- "second"
+		}
+	}
+	for i, fn := range []func(){first, second} {
+		go fn()
+		require.Eventually(t, func() bool {
+			members, _ := mr.ZMembers(keys[2])
+			return len(members) == i+1
+		}, time.Second, time.Millisecond)
+	}
+	// 过了好几个心跳周期，先来的人还排在前面
+	time.Sleep(time.Millisecond * 300)
+	members, _ := mr.ZMembers(keys[2])
+	assert.Len(t, members, 2)
+
+	// 锁自然过期，没有释放的通知，等到先来的人下一次重试才能拿到锁
+	mr.Del("key1")
+	assert.Equal(t, "first", <-acquired)
+	assert.Equal(t, "second", <-acquired)
+}
+
+func TestFairLock_Cancel(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+	keys := fairKeys("key1")
+
+	// 排在前面的等待者还活着，排队的序号从 1 开始
+	_, err := mr.ZAdd(keys[2], 0, "alive")
+	require.NoError(t, err)
+	_, err = mr.ZAdd(keys[3], float64(time.Now().Add(time.Minute).UnixMilli()), "alive")
+	require.NoError(t, err)
+	_, err = c.FairLock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 2})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+
+	// 放弃之后撤销排队
+	members, _ := mr.ZMembers(keys[2])
+	assert.Equal(t, []string{"alive"}, members)
+	members, _ = mr.ZMembers(keys[3])
+	assert.Equal(t, []string{"alive"}, members)
+}
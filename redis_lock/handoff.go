@@ -0,0 +1,59 @@
+package redis_lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// lockHandle 是 Lock 序列化之后的格式，用于在进程之间传递锁
+type lockHandle struct {
+	Key        string        `json:"key"`
+	Value      string        `json:"value"`
+	Expiration time.Duration `json:"expiration"`
+	Token      int64         `json:"token"`
+}
+
+// Marshal 把锁序列化，交给别的进程用 Client.Resume 恢复
+// 比如调度进程加锁，工作进程负责续约和释放。
+// 序列化的结果里面有锁的 value，拿到它就能释放锁，不要泄露出去
+func (l *Lock) Marshal() ([]byte, error) {
+	return json.Marshal(lockHandle{
+		Key:        l.key,
+		Value:      l.value,
+		Expiration: l.expiration,
+		Token:      l.token,
+	})
+}
+
+// Resume 从 Marshal 的结果恢复锁，恢复之前会检查锁是否还被持有
+// 锁已经过期或者被别人拿走的时候返回 ErrLockNotHold
+func (c *Client) Resume(ctx context.Context, data []byte) (*Lock, error) {
+	var h lockHandle
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("redis-lock: 无法解析锁, %w", err)
+	}
+	if h.Key == "" || h.Value == "" {
+		return nil, errors.New("redis-lock: 无法解析锁, key 和 value 不能为空")
+	}
+	// 过期时间不对的锁续约的时候会让 key 马上过期
+	if h.Expiration <= 0 {
+		return nil, errors.New("redis-lock: 无法解析锁, 过期时间必须大于 0")
+	}
+	start := c.clock.Now()
+	ttl, err := c.client.Eval(ctx, luaCheck, []string{h.Key}, h.Value).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 || ttl < -1 {
+		return nil, ErrLockNotHold
+	}
+	// 锁还能保持多久要看 redis 上剩下的过期时间，而不是从现在开始算 expiration
+	refreshedAt := start
+	if ttl > 0 {
+		refreshedAt = start.Add(time.Duration(ttl)*time.Millisecond - h.Expiration)
+	}
+	return newLock(c, c.clock, h.Key, h.Value, h.Expiration, h.Token, refreshedAt), nil
+}
@@ -0,0 +1,72 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestClient_Resume(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	data, err := l.Marshal()
+	require.NoError(t, err)
+
+	// 恢复出来的锁和原来的一样，可以续约和释放
+	resumed, err := c.Resume(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, l.key, resumed.key)
+	assert.Equal(t, l.value, resumed.value)
+	assert.Equal(t, l.expiration, resumed.expiration)
+	assert.Equal(t, l.Token(), resumed.Token())
+	require.NoError(t, resumed.Refresh(ctx))
+	require.NoError(t, resumed.Unlock(ctx))
+	assert.False(t, mr.Exists("key1"))
+
+	// 锁已经释放了
+	_, err = c.Resume(ctx, data)
+	assert.Equal(t, ErrLockNotHold, err)
+
+	// 恢复出来的锁按照 redis 上剩下的过期时间过期
+	l, err = c.TryLock(ctx, "key2", time.Second*10)
+	require.NoError(t, err)
+	data, err = l.Marshal()
+	require.NoError(t, err)
+	mr.SetTTL("key2", time.Millisecond*100)
+	resumed, err = c.Resume(ctx, data)
+	require.NoError(t, err)
+	select {
+	case <-resumed.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("锁应该已经过期")
+	}
+}
+
+func TestClient_ResumeInvalid(t *testing.T) {
+	c, _ := newClientForTest(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "not json", data: "abc"},
+		{name: "empty key", data: `{"value":"v","expiration":1000000000}`},
+		{name: "empty value", data: `{"key":"key1","expiration":1000000000}`},
+		{name: "zero expiration", data: `{"key":"key1","value":"v"}`},
+		{name: "negative expiration", data: `{"key":"key1","value":"v","expiration":-1}`},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := c.Resume(ctx, []byte(tc.data))
+			assert.Error(t, err)
+			assert.NotEqual(t, ErrLockNotHold, err)
+		})
+	}
+}
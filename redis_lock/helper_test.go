@@ -0,0 +1,14 @@
+package redis_lock
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+)
+
+// newClientForTest 启动一个 miniredis，返回连到它上面的 Client，所有测试共用这一个入口
+// miniredis 会在测试结束的时候自动关闭
+func newClientForTest(t *testing.T, opts ...ClientOption) (*Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return NewClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), opts...), mr
+}
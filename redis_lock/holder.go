@@ -0,0 +1,198 @@
+package redis_lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"time"
+)
+
+var (
+	ErrLockNotExist = errors.New("redis-lock: 锁不存在")
+
+	hostname, _ = os.Hostname()
+)
+
+// auditMaxLen 每个 key 最多保留多少条审计记录
+const auditMaxLen = 100
+
+// LockInfo 锁的持有者信息
+// Lock、TryLock 和 FairLock 会把它编码成 JSON 作为锁的 value 存进 redis
+type LockInfo struct {
+	// 唯一 id，保证每次加锁的 value 都不一样
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	Pid      int    `json:"pid"`
+	// 拿到锁的时间，Lock 和 FairLock 在等待的过程中每次尝试加锁都会更新它
+	AcquiredAt time.Time `json:"acquired_at"`
+	// 调用方通过 WithLabel 传入的标签
+	Label string `json:"label,omitempty"`
+
+	// 下面两个字段不在 value 里面，是 Inspect 的时候查出来的
+	// TTL 锁的剩余过期时间
+	TTL time.Duration `json:"-"`
+	// Token 当前的 fencing token
+	Token int64 `json:"-"`
+}
+
+// AuditEntry ForceUnlock 留下的审计记录
+type AuditEntry struct {
+	Key string `json:"key"`
+	// 被强制释放的锁的持有者
+	Holder LockInfo `json:"holder"`
+	// 执行强制释放的进程，ID 为空
+	Operator LockInfo  `json:"operator"`
+	At       time.Time `json:"at"`
+}
+
+type labelKey struct{}
+
+// WithLabel 给这次加锁带上一个标签，比如任务名或者请求 id，
+// 出问题的时候可以通过 Inspect 看到是谁拿着锁
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+func labelFrom(ctx context.Context) string {
+	label, _ := ctx.Value(labelKey{}).(string)
+	return label
+}
+
+func auditKey(key string) string {
+	return key + ":audit"
+}
+
+// newLockValue 生成带有持有者信息的锁的 value
+func newLockValue(ctx context.Context) string {
+	return newLockValues(ctx).val
+}
+
+// lockValue 等待锁的过程中使用的 value
+// 确定没有抢到锁之后，下一次尝试之前会把 AcquiredAt 更新成当前时间，拿到锁之后就是真正拿到锁的时间。
+// 尝试超时的时候结果不确定，可能已经加锁成功了，这时候要用同一个 value 重试，
+// lua 脚本才能认出来是自己加的锁
+type lockValue struct {
+	info LockInfo
+	val  string
+	// 上一次尝试确定没有抢到锁
+	failed bool
+}
+
+func newLockValues(ctx context.Context) *lockValue {
+	v := &lockValue{info: LockInfo{
+		ID:         uuid.New().String(),
+		Hostname:   hostname,
+		Pid:        os.Getpid(),
+		AcquiredAt: time.Now(),
+		Label:      labelFrom(ctx),
+	}}
+	v.encode()
+	return v
+}
+
+func (v *lockValue) encode() {
+	data, _ := json.Marshal(v.info)
+	v.val = string(data)
+}
+
+// attempt 用这次尝试的 value 调用 fn 加锁，fn 返回 fencing token
+func (v *lockValue) attempt(fn func(val string) (int64, error)) (int64, error) {
+	if v.failed {
+		v.info.AcquiredAt = time.Now()
+		v.encode()
+	}
+	token, err := fn(v.val)
+	v.failed = err == nil && token <= 0
+	return token, err
+}
+
+// parseLockInfo 解析锁的 value，不是 JSON 的话说明是老版本写入的 uuid
+func parseLockInfo(val string) LockInfo {
+	var info LockInfo
+	if err := json.Unmarshal([]byte(val), &info); err != nil || info.ID == "" {
+		return LockInfo{ID: val}
+	}
+	return info
+}
+
+// Inspect 查看锁的持有者，锁不存在的时候返回 ErrLockNotExist
+func (c *Client) Inspect(ctx context.Context, key string) (*LockInfo, error) {
+	var valCmd *redis.StringCmd
+	var ttlCmd *redis.DurationCmd
+	var tokenCmd *redis.StringCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		valCmd = pipe.Get(ctx, key)
+		ttlCmd = pipe.PTTL(ctx, key)
+		tokenCmd = pipe.Get(ctx, fencingKey(key))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	val, err := valCmd.Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrLockNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := parseLockInfo(val)
+	info.TTL = ttlCmd.Val()
+	info.Token, _ = tokenCmd.Int64()
+	return &info, nil
+}
+
+// ForceUnlock 不管是谁的锁都直接释放，并且留下审计记录，只应该在处理事故的时候使用
+// 锁不存在的时候返回 ErrLockNotExist
+func (c *Client) ForceUnlock(ctx context.Context, key string) error {
+	val, err := c.client.Eval(ctx, luaForceUnlock, []string{key}, unlockChannel(key)).Text()
+	if errors.Is(err, redis.Nil) {
+		return ErrLockNotExist
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(AuditEntry{
+		Key:    key,
+		Holder: parseLockInfo(val),
+		Operator: LockInfo{
+			Hostname: hostname,
+			Pid:      os.Getpid(),
+			Label:    labelFrom(ctx),
+		},
+		At: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, auditKey(key), data)
+		pipe.LTrim(ctx, auditKey(key), 0, auditMaxLen-1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis-lock: 锁已经释放，但是审计记录写入失败, %w", err)
+	}
+	return nil
+}
+
+// AuditLog 返回 key 的强制释放记录，最新的在前面
+func (c *Client) AuditLog(ctx context.Context, key string) ([]AuditEntry, error) {
+	vals, err := c.client.LRange(ctx, auditKey(key), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(vals))
+	for _, val := range vals {
+		var entry AuditEntry
+		if err = json.Unmarshal([]byte(val), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
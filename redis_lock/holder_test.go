@@ -0,0 +1,114 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClient_Inspect(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	_, err := c.Inspect(ctx, "key1")
+	assert.Equal(t, ErrLockNotExist, err)
+
+	before := time.Now()
+	l, err := c.TryLock(WithLabel(ctx, "job-1"), "key1", time.Second*10)
+	require.NoError(t, err)
+	info, err := c.Inspect(ctx, "key1")
+	require.NoError(t, err)
+	assert.NotEmpty(t, info.ID)
+	assert.Equal(t, hostname, info.Hostname)
+	assert.Equal(t, os.Getpid(), info.Pid)
+	assert.Equal(t, "job-1", info.Label)
+	assert.False(t, info.AcquiredAt.Before(before))
+	assert.Equal(t, time.Second*10, info.TTL)
+	assert.Equal(t, l.Token(), info.Token)
+
+	// 老版本写入的 value 是 uuid
+	mr.Set("key2", "abc")
+	info, err = c.Inspect(ctx, "key2")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", info.ID)
+	assert.Equal(t, int64(0), info.Token)
+}
+
+func TestClient_AcquiredAtAfterWait(t *testing.T) {
+	c, _ := newClientForTest(t)
+	ctx := context.Background()
+	retry := &FixedIntervalRetryStrategy{Interval: time.Millisecond * 10, MaxCnt: 100}
+
+	for _, lock := range []func() (*Lock, error){
+		func() (*Lock, error) { return c.Lock(ctx, "key1", time.Second*10, time.Second, retry) },
+		func() (*Lock, error) { return c.FairLock(ctx, "key1", time.Second*10, time.Second, retry) },
+	} {
+		holder, err := c.TryLock(ctx, "key1", time.Second*10)
+		require.NoError(t, err)
+		done := make(chan *Lock, 1)
+		go func(lock func() (*Lock, error)) {
+			l, err := lock()
+			assert.NoError(t, err)
+			done <- l
+		}(lock)
+		time.Sleep(time.Millisecond * 50)
+		released := time.Now()
+		require.NoError(t, holder.Unlock(ctx))
+
+		// 等待之后拿到的锁，AcquiredAt 是真正拿到锁的时间
+		l := <-done
+		require.NotNil(t, l)
+		info, err := c.Inspect(ctx, "key1")
+		require.NoError(t, err)
+		assert.False(t, info.AcquiredAt.Before(released))
+		require.NoError(t, l.Unlock(ctx))
+	}
+}
+
+func TestClient_ForceUnlock(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	assert.Equal(t, ErrLockNotExist, c.ForceUnlock(ctx, "key1"))
+	entries, err := c.AuditLog(ctx, "key1")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for _, label := range []string{"job-1", "job-2"} {
+		l, err := c.TryLock(WithLabel(ctx, label), "key1", time.Second*10)
+		require.NoError(t, err)
+		require.NoError(t, c.ForceUnlock(WithLabel(ctx, "admin"), "key1"))
+		assert.False(t, mr.Exists("key1"))
+		// 原来的持有者已经不持有锁了
+		assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+	}
+
+	// 最新的记录在前面
+	entries, err = c.AuditLog(ctx, "key1")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for i, label := range []string{"job-2", "job-1"} {
+		assert.Equal(t, "key1", entries[i].Key)
+		assert.Equal(t, label, entries[i].Holder.Label)
+		assert.NotEmpty(t, entries[i].Holder.ID)
+		assert.Equal(t, "admin", entries[i].Operator.Label)
+		assert.Empty(t, entries[i].Operator.ID)
+		assert.Equal(t, os.Getpid(), entries[i].Operator.Pid)
+	}
+}
+
+func TestClient_AuditLogTrim(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	for i := 0; i < auditMaxLen+10; i++ {
+		mr.Set("key1", "abc")
+		require.NoError(t, c.ForceUnlock(ctx, "key1"))
+	}
+	entries, err := c.AuditLog(ctx, "key1")
+	require.NoError(t, err)
+	assert.Len(t, entries, auditMaxLen)
+}
@@ -0,0 +1,110 @@
+package redis_lock
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// refreshTime 记录最后一次续约成功的续约发起时间，加锁成功也算一次
+// Lock 之外的锁用它来计算 AutoRefresh 什么时候放弃，续约可能是并发的，时间只会往后推
+type refreshTime struct {
+	nanos atomic.Int64
+}
+
+func (r *refreshTime) update(t time.Time) {
+	for {
+		old := r.nanos.Load()
+		if t.UnixNano() <= old || r.nanos.CompareAndSwap(old, t.UnixNano()) {
+			return
+		}
+	}
+}
+
+func (r *refreshTime) load() time.Time {
+	return time.Unix(0, r.nanos.Load())
+}
+
+// newLock 创建锁，refreshedAt 是按照 clock 发起加锁之前的时间，
+// 锁在 redis 上至少能保持到 refreshedAt + expiration
+func newLock(locker Locker, clock Clock, key string, val string, expiration time.Duration, token int64, refreshedAt time.Time) *Lock {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	l := &Lock{
+		locker:      locker,
+		clock:       clock,
+		key:         key,
+		value:       val,
+		expiration:  expiration,
+		token:       token,
+		unlockChan:  make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		lost:        make(chan struct{}),
+		refreshedAt: refreshedAt,
+	}
+	l.expiry = clock.AfterFunc(refreshedAt.Add(expiration).Sub(clock.Now()), func() {
+		l.markLost(fmt.Errorf("redis-lock: 锁已经过期, %w", ErrLockNotHold))
+	})
+	return l
+}
+
+// extend 续约成功之后推迟过期的时间，attemptStart 是发起续约之前的时间
+// 续约可能是并发的，过期时间只会往后推
+func (l *Lock) extend(attemptStart time.Time) {
+	l.expiryMutex.Lock()
+	defer l.expiryMutex.Unlock()
+	if !attemptStart.After(l.refreshedAt) {
+		return
+	}
+	select {
+	case <-l.lost:
+		// 已经丢失的锁不会再恢复
+		return
+	default:
+	}
+	l.refreshedAt = attemptStart
+	l.expiry.Reset(attemptStart.Add(l.expiration).Sub(l.clock.Now()))
+}
+
+// lastRefreshed 返回最后一次续约成功的续约发起时间
+func (l *Lock) lastRefreshed() time.Time {
+	l.expiryMutex.Lock()
+	defer l.expiryMutex.Unlock()
+	return l.refreshedAt
+}
+
+// Lost 返回一个 channel，锁丢失的时候会被关闭
+// 续约的时候发现锁已经不是自己的，或者到了过期时间还没有续约成功，都算丢失；
+// 过期时间按照本地时间计算（MemoryLocker 的锁按照它的 Clock），从发起加锁或者最后一次成功续约之前开始算。
+// 主动 Unlock 不算丢失
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Context 返回一个和锁绑定的 context，锁丢失或者被释放的时候会被取消
+// 业务代码应该在这个 context 下执行，锁没了业务就能及时停下来。
+// 可以通过 context.Cause 拿到原因：锁丢失的时候是 ErrLockNotHold，释放的时候是 ErrLockReleased
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// KeepAlive 在后台自动续约，不会阻塞调用方
+// 续约失败的时候锁会被标记为丢失，Lost 和 Context 都会收到通知；Unlock 之后后台续约会自动结束
+func (l *Lock) KeepAlive(interval time.Duration, timeout time.Duration) {
+	go func() {
+		_ = l.AutoRefresh(interval, timeout)
+	}()
+}
+
+// markLost 标记锁已经丢失
+// 已经调用过 Unlock 的锁不会被标记为丢失
+func (l *Lock) markLost(cause error) {
+	if l.released.Load() {
+		return
+	}
+	l.lostOnce.Do(func() {
+		l.cancel(cause)
+		close(l.lost)
+	})
+}
@@ -0,0 +1,300 @@
+package redis_lock
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLock_Lost(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	select {
+	case <-l.Lost():
+		t.Fatal("锁还没有丢失")
+	default:
+	}
+	require.NoError(t, l.Context().Err())
+
+	// 锁被别人删掉了，续约的时候发现丢失
+	mr.Del("key1")
+	assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+	select {
+	case <-l.Lost():
+	default:
+		t.Fatal("锁应该已经丢失")
+	}
+	assert.ErrorIs(t, context.Cause(l.Context()), ErrLockNotHold)
+}
+
+func TestLock_UnlockNotLost(t *testing.T) {
+	c, _ := newClientForTest(t)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock(ctx))
+	// 主动释放之后 context 被取消，但是不算丢失
+	assert.ErrorIs(t, context.Cause(l.Context()), ErrLockReleased)
+	assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+	select {
+	case <-l.Lost():
+		t.Fatal("主动释放不算丢失")
+	default:
+	}
+}
+
+func TestLock_Expire(t *testing.T) {
+	c, _ := newClientForTest(t)
+	ctx := context.Background()
+
+	// 没有续约，到了过期时间 context 就被取消，不用等到下一次续约
+	start := time.Now()
+	l, err := c.TryLock(ctx, "key1", time.Millisecond*100)
+	require.NoError(t, err)
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("锁应该已经过期")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*100)
+	assert.ErrorIs(t, context.Cause(l.Context()), ErrLockNotHold)
+
+	// 续约成功之后过期时间从发起续约的时候开始算
+	l, err = c.TryLock(ctx, "key2", time.Millisecond*200)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 100)
+	refreshed := time.Now()
+	require.NoError(t, l.Refresh(ctx))
+	time.Sleep(time.Millisecond * 150)
+	require.NoError(t, l.Context().Err())
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("锁应该已经过期")
+	}
+	assert.GreaterOrEqual(t, time.Since(refreshed), time.Millisecond*200)
+
+	// 主动释放之后不会再被标记为过期
+	l, err = c.TryLock(ctx, "key3", time.Millisecond*50)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock(ctx))
+	time.Sleep(time.Millisecond * 100)
+	assert.ErrorIs(t, context.Cause(l.Context()), ErrLockReleased)
+}
+
+func TestLock_KeepAlive(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	l, err := c.TryLock(ctx, "key1", time.Second)
+	require.NoError(t, err)
+	l.KeepAlive(time.Millisecond*10, time.Second)
+	// 一直在续约，过了几个过期时间锁还在
+	for i := 0; i < 3; i++ {
+		mr.FastForward(time.Millisecond * 600)
+		require.Eventually(t, func() bool {
+			return mr.TTL("key1") == time.Second
+		}, time.Second, time.Millisecond)
+	}
+	require.True(t, mr.Exists("key1"))
+	require.NoError(t, l.Unlock(ctx))
+	// 等后台续约结束，主动释放不会被当成丢失
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case <-l.Lost():
+		t.Fatal("主动释放不算丢失")
+	default:
+	}
+
+	// 锁被别人拿走了，后台续约发现之后标记丢失
+	l, err = c.TryLock(ctx, "key2", time.Second)
+	require.NoError(t, err)
+	l.KeepAlive(time.Millisecond*10, time.Second)
+	mr.Del("key2")
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("锁应该已经丢失")
+	}
+	assert.ErrorIs(t, context.Cause(l.Context()), ErrLockNotHold)
+}
+
+// fakeRefresh 包装 refresh，成功的时候和真正的锁一样，把有效期推迟到发起续约的时间加上 expiration
+func fakeRefresh(refresh func(ctx context.Context) error, start time.Time,
+	expiration time.Duration) (func(ctx context.Context) error, func() time.Time) {
+	var refreshed refreshTime
+	refreshed.update(start)
+	return func(ctx context.Context) error {
+			attemptStart := time.Now()
+			err := refresh(ctx)
+			if err == nil {
+				refreshed.update(attemptStart)
+			}
+			return err
+		}, func() time.Time {
+			return refreshed.load().Add(expiration)
+		}
+}
+
+func TestAutoRefresh_TransientErrors(t *testing.T) {
+	ioErr := errors.New("read tcp: i/o timeout")
+	testCases := []struct {
+		name    string
+		refresh func(ctx context.Context) error
+		wantErr error
+		// 至少要坚持多久才放弃
+		wantWait time.Duration
+	}{
+		{
+			// 网络错误和超时都当作暂时的，一直失败到锁过期才放弃
+			name: "一直出错",
+			refresh: func(ctx context.Context) error {
+				return ioErr
+			},
+			wantErr:  ErrLockNotHold,
+			wantWait: time.Millisecond * 200,
+		},
+		{
+			name: "一直超时",
+			refresh: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantErr:  ErrLockNotHold,
+			wantWait: time.Millisecond * 200,
+		},
+		{
+			name: "锁已经不是自己的",
+			refresh: func(ctx context.Context) error {
+				return ErrLockNotHold
+			},
+			wantErr: ErrLockNotHold,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			refresh, validUntil := fakeRefresh(tc.refresh, start, time.Millisecond*200)
+			err := autoRefresh(realClock{}, refresh, make(chan struct{}), time.Millisecond*10, time.Millisecond*10, validUntil)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.GreaterOrEqual(t, time.Since(start), tc.wantWait)
+		})
+	}
+}
+
+func TestAutoRefresh_ExpireBetweenTicks(t *testing.T) {
+	// 续约间隔比过期时间还长，到了过期时间马上返回，不用等下一次续约
+	start := time.Now()
+	refresh, validUntil := fakeRefresh(func(ctx context.Context) error {
+		return nil
+	}, start, time.Millisecond*100)
+	err := autoRefresh(realClock{}, refresh, make(chan struct{}), time.Hour, time.Second, validUntil)
+	assert.ErrorIs(t, err, ErrLockNotHold)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 过期时间从发起续约的时候开始算，慢的续约不会把过期时间往后推
+	var calls atomic.Int32
+	start = time.Now()
+	refresh, validUntil = fakeRefresh(func(ctx context.Context) error {
+		if calls.Add(1) == 1 {
+			time.Sleep(time.Millisecond * 150)
+			return nil
+		}
+		return errors.New("connection refused")
+	}, start, time.Millisecond*200)
+	err = autoRefresh(realClock{}, refresh, make(chan struct{}), time.Millisecond*10, time.Second, validUntil)
+	assert.ErrorIs(t, err, ErrLockNotHold)
+	// 第一次续约在 10ms 左右发起，所以在 210ms 左右过期，而不是 360ms
+	assert.Less(t, time.Since(start), time.Millisecond*300)
+}
+
+func TestAutoRefresh_StartedLate(t *testing.T) {
+	// 加锁之后过了一段时间才开始自动续约，放弃的时间还是从加锁的时候开始算
+	const expiration = time.Millisecond * 300
+	testCases := []struct {
+		name string
+		lock func(t *testing.T, c *Client) func(interval, timeout time.Duration) error
+	}{
+		{
+			name: "Lock",
+			lock: func(t *testing.T, c *Client) func(interval, timeout time.Duration) error {
+				l, err := c.TryLock(context.Background(), "key1", expiration)
+				require.NoError(t, err)
+				return l.AutoRefresh
+			},
+		},
+		{
+			name: "RWLock",
+			lock: func(t *testing.T, c *Client) func(interval, timeout time.Duration) error {
+				l, err := c.TryRLock(context.Background(), "key1", expiration)
+				require.NoError(t, err)
+				return l.AutoRefresh
+			},
+		},
+		{
+			name: "ReentrantLock",
+			lock: func(t *testing.T, c *Client) func(interval, timeout time.Duration) error {
+				l, err := c.TryLockReentrant(context.Background(), "key1", NewOwner(), expiration)
+				require.NoError(t, err)
+				return l.AutoRefresh
+			},
+		},
+		{
+			name: "Permit",
+			lock: func(t *testing.T, c *Client) func(interval, timeout time.Duration) error {
+				p, err := NewSemaphore(c.client).TryAcquire(context.Background(), "key1", 1, expiration)
+				require.NoError(t, err)
+				return p.AutoRefresh
+			},
+		},
+		{
+			name: "MultiLock",
+			lock: func(t *testing.T, c *Client) func(interval, timeout time.Duration) error {
+				l, err := NewMultiClient(c.client).TryLock(context.Background(), "key1", expiration)
+				require.NoError(t, err)
+				return l.AutoRefresh
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, mr := newClientForTest(t)
+			autoRefresh := tc.lock(t, c)
+			time.Sleep(time.Millisecond * 200)
+			// redis 一直出错，锁在加锁之后 300ms 左右就过期了
+			mr.SetError("连接断开")
+			start := time.Now()
+			err := autoRefresh(time.Millisecond*10, time.Millisecond*10)
+			assert.ErrorIs(t, err, ErrLockNotHold)
+			assert.Less(t, time.Since(start), time.Millisecond*250)
+		})
+	}
+}
+
+func TestAutoRefresh_Recover(t *testing.T) {
+	// 出错的时间没有超过 expiration，恢复之后继续续约
+	var calls atomic.Int32
+	refresh, validUntil := fakeRefresh(func(ctx context.Context) error {
+		if n := calls.Add(1); n%5 != 0 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, time.Now(), time.Millisecond*200)
+	unlockChan := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- autoRefresh(realClock{}, refresh, unlockChan, time.Millisecond*10, time.Millisecond*10, validUntil)
+	}()
+	time.Sleep(time.Millisecond * 500)
+	unlockChan <- struct{}{}
+	require.NoError(t, <-done)
+	assert.Greater(t, calls.Load(), int32(20))
+}
@@ -0,0 +1,27 @@
+package redis_lock
+
+import (
+	"context"
+	"time"
+)
+
+// Locker 分布式锁的抽象
+// Client 是基于 redis 的实现，MemoryLocker 是进程内的实现，
+// 依赖 Locker 的业务代码在单元测试里面可以用 MemoryLocker 代替 redis
+type Locker interface {
+	// TryLock 尝试加锁，锁被人拿着的时候返回 ErrFailedToPreemptLock
+	TryLock(ctx context.Context, key string, expiration time.Duration) (*Lock, error)
+	// Lock 加锁，锁被人拿着的时候按照重试策略进行重试
+	Lock(ctx context.Context, key string, expiration time.Duration, timeout time.Duration, retry RetryStrategy) (*Lock, error)
+	// Refresh 续约，锁已经不是自己的时候返回 ErrLockNotHold
+	// 一般直接调用 Lock.Refresh
+	Refresh(ctx context.Context, l *Lock) error
+	// Unlock 释放锁，锁已经不是自己的时候返回 ErrLockNotHold
+	// 一般直接调用 Lock.Unlock
+	Unlock(ctx context.Context, l *Lock) error
+}
+
+var (
+	_ Locker = &Client{}
+	_ Locker = &MemoryLocker{}
+)
@@ -0,0 +1,179 @@
+package redis_lock
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// lockerFactory 创建一个 Locker，以及推进它的时间的方法
+type lockerFactory func(t *testing.T) (Locker, func(d time.Duration))
+
+func TestClient_Conformance(t *testing.T) {
+	testLockerConformance(t, func(t *testing.T) (Locker, func(d time.Duration)) {
+		c, mr := newClientForTest(t)
+		// redis 上的过期时间和锁判断丢失用的时钟一起推进
+		clock := NewFakeClock(time.Now())
+		c.clock = clock
+		return c, func(d time.Duration) {
+			mr.FastForward(d)
+			clock.Advance(d)
+		}
+	})
+}
+
+func TestMemoryLocker_Conformance(t *testing.T) {
+	testLockerConformance(t, func(t *testing.T) (Locker, func(d time.Duration)) {
+		clock := NewFakeClock(time.Now())
+		return NewMemoryLocker(clock), clock.Advance
+	})
+}
+
+// countRetry 最多重试 cnt 次
+type countRetry struct {
+	interval time.Duration
+	cnt      int
+}
+
+func (r *countRetry) Next() (time.Duration, bool) {
+	if r.cnt <= 0 {
+		return 0, false
+	}
+	r.cnt--
+	return r.interval, true
+}
+
+// testLockerConformance 所有 Locker 实现都要通过的测试
+func testLockerConformance(t *testing.T, factory lockerFactory) {
+	const expiration = time.Second * 10
+	ctx := context.Background()
+
+	t.Run("TryLock 互斥", func(t *testing.T) {
+		locker, _ := factory(t)
+		l, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		_, err = locker.TryLock(ctx, "key1", expiration)
+		assert.Equal(t, ErrFailedToPreemptLock, err)
+		// 不同的 key 互不影响
+		_, err = locker.TryLock(ctx, "key2", expiration)
+		require.NoError(t, err)
+
+		require.NoError(t, l.Unlock(ctx))
+		assert.Equal(t, ErrLockNotHold, l.Unlock(ctx))
+		_, err = locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+	})
+
+	t.Run("过期之后可以被别人拿到", func(t *testing.T) {
+		locker, advance := factory(t)
+		l1, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		advance(expiration + time.Second)
+		l2, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		assert.Greater(t, l2.Token(), l1.Token())
+		assert.Equal(t, ErrLockNotHold, l1.Refresh(ctx))
+		assert.Equal(t, ErrLockNotHold, l1.Unlock(ctx))
+		require.NoError(t, l2.Unlock(ctx))
+	})
+
+	t.Run("续约", func(t *testing.T) {
+		locker, advance := factory(t)
+		l, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		advance(expiration * 6 / 10)
+		require.NoError(t, l.Refresh(ctx))
+		advance(expiration * 6 / 10)
+		_, err = locker.TryLock(ctx, "key1", expiration)
+		assert.Equal(t, ErrFailedToPreemptLock, err)
+		require.NoError(t, l.Unlock(ctx))
+	})
+
+	t.Run("过期之后丢失", func(t *testing.T) {
+		locker, advance := factory(t)
+		l, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		advance(expiration * 6 / 10)
+		require.NoError(t, l.Refresh(ctx))
+		// 过期时间从续约的时候重新算
+		advance(expiration * 6 / 10)
+		select {
+		case <-l.Lost():
+			t.Fatal("锁还没有过期")
+		default:
+		}
+		require.NoError(t, l.Context().Err())
+
+		// 没有再续约，到了过期时间就丢失，不用等到下一次续约
+		advance(expiration * 5 / 10)
+		select {
+		case <-l.Lost():
+		default:
+			t.Fatal("锁应该已经丢失")
+		}
+		assert.ErrorIs(t, context.Cause(l.Context()), ErrLockNotHold)
+	})
+
+	t.Run("释放之后不算丢失", func(t *testing.T) {
+		locker, advance := factory(t)
+		l, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		require.NoError(t, l.Unlock(ctx))
+		assert.ErrorIs(t, context.Cause(l.Context()), ErrLockReleased)
+		advance(expiration + time.Second)
+		select {
+		case <-l.Lost():
+			t.Fatal("主动释放不算丢失")
+		default:
+		}
+		assert.ErrorIs(t, context.Cause(l.Context()), ErrLockReleased)
+	})
+
+	t.Run("Lock 超出重试次数", func(t *testing.T) {
+		locker, advance := factory(t)
+		_, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := locker.Lock(ctx, "key1", expiration, time.Second, &countRetry{interval: time.Millisecond, cnt: 3})
+			done <- err
+		}()
+		deadline := time.After(time.Second)
+	loop:
+		for {
+			select {
+			case err = <-done:
+				break loop
+			case <-time.After(time.Millisecond * 10):
+				// 内存实现要推进时钟才会重试
+				advance(time.Millisecond)
+			case <-deadline:
+				t.Fatal("Lock 没有返回")
+			}
+		}
+		assert.True(t, errors.Is(err, ErrFailedToPreemptLock))
+	})
+
+	t.Run("Lock 等到锁被释放", func(t *testing.T) {
+		locker, _ := factory(t)
+		l, err := locker.TryLock(ctx, "key1", expiration)
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			// 重试间隔很长，只能靠释放通知唤醒
+			_, err := locker.Lock(ctx, "key1", expiration, time.Second, &countRetry{interval: time.Hour, cnt: 1})
+			done <- err
+		}()
+		time.Sleep(time.Millisecond * 50)
+		require.NoError(t, l.Unlock(ctx))
+		select {
+		case err = <-done:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Lock 没有被唤醒")
+		}
+	})
+}
@@ -0,0 +1,8 @@
+--检查是不是你的锁，是的话返回剩下的过期时间（毫秒），key 没有过期时间的时候返回 -1
+-- KEYS[1] 就是你的分布式锁的key
+-- ARGV[1] 就是你预期的存在redis 里面的 value
+if redis.call('get', KEYS[1]) == ARGV[1] then
+    return redis.call('pttl', KEYS[1])
+else
+    return -2
+end
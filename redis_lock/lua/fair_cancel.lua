@@ -0,0 +1,11 @@
+-- 放弃排队，并且通知后面的等待者
+-- KEYS[1] 等待队列
+-- KEYS[2] 等待者的心跳
+-- ARGV[1] 等待者的 id
+-- ARGV[2] 要通知的 channel
+local res = redis.call('zrem', KEYS[1], ARGV[1])
+redis.call('zrem', KEYS[2], ARGV[1])
+if res == 1 then
+    redis.call('publish', ARGV[2], ARGV[1])
+end
+return res
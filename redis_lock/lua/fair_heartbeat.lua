@@ -0,0 +1,19 @@
+-- 公平锁等待者的心跳，还在队列里面的时候才刷新
+-- KEYS[1] 等待队列
+-- KEYS[2] 等待者的心跳，zset，score 是心跳的过期时间（毫秒）
+-- KEYS[3] 排队序号的计数器
+-- ARGV[1] 等待者的 id
+-- ARGV[2] 心跳的过期时间，单位毫秒
+if redis.call('zscore', KEYS[1], ARGV[1]) == false then
+    --    还没有排队，或者已经被踢出去了
+    return 0
+end
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('zadd', KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+for i = 1, 3 do
+    if redis.call('pttl', KEYS[i]) < tonumber(ARGV[2]) then
+        redis.call('pexpire', KEYS[i], ARGV[2])
+    end
+end
+return 1
@@ -0,0 +1,54 @@
+-- 公平锁，等待者按照先来后到排队，只有队头才能加锁
+-- KEYS[1] 就是你的分布式锁的key
+-- KEYS[2] fencing token 计数器
+-- KEYS[3] 等待队列，zset，score 是排队的序号
+-- KEYS[4] 等待者的心跳，zset，score 是心跳的过期时间（毫秒）
+-- KEYS[5] 排队序号的计数器
+-- ARGV[1] 就是你预期的存在redis 里面的 value
+-- ARGV[2] 锁的过期时间，单位毫秒
+-- ARGV[3] 心跳的过期时间，单位毫秒
+-- ARGV[4] 等待者的 id，每次尝试都一样，value 可能会变
+-- 加锁成功返回 fencing token，失败返回 0
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+-- 心跳过期的等待者说明已经挂了，把它从队列里面踢掉
+local dead = redis.call('zrangebyscore', KEYS[4], '-inf', now)
+for _, id in ipairs(dead) do
+    redis.call('zrem', KEYS[3], id)
+    redis.call('zrem', KEYS[4], id)
+end
+
+local val = redis.call('get', KEYS[1])
+if val == ARGV[1] then
+    --    你上次加锁成功了
+    redis.call('pexpire', KEYS[1], ARGV[2])
+    local token = redis.call('get', KEYS[2])
+    if token == false then
+        token = redis.call('incr', KEYS[2])
+    end
+    return tonumber(token)
+end
+
+-- 排队，已经在队列里面的就只更新心跳
+if redis.call('zscore', KEYS[3], ARGV[4]) == false then
+    redis.call('zadd', KEYS[3], redis.call('incr', KEYS[5]), ARGV[4])
+end
+redis.call('zadd', KEYS[4], now + tonumber(ARGV[3]), ARGV[4])
+redis.call('pexpire', KEYS[3], ARGV[3])
+redis.call('pexpire', KEYS[4], ARGV[3])
+redis.call('pexpire', KEYS[5], ARGV[3])
+
+if val ~= false then
+    --    锁被人拿着
+    return 0
+end
+local head = redis.call('zrange', KEYS[3], 0, 0)
+if head[1] ~= ARGV[4] then
+    --    还没轮到你
+    return 0
+end
+redis.call('set', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('zrem', KEYS[3], ARGV[4])
+redis.call('zrem', KEYS[4], ARGV[4])
+return redis.call('incr', KEYS[2])
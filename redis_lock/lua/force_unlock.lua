@@ -0,0 +1,11 @@
+--不管是谁的锁，直接删除，只给管理员用
+-- KEYS[1] 就是你的分布式锁的key
+-- ARGV[1] 释放之后要通知的 channel
+-- 返回被删除的锁的 value，锁不存在的时候返回 nil
+local val = redis.call('get', KEYS[1])
+if val == false then
+    return false
+end
+redis.call('del', KEYS[1])
+redis.call('publish', ARGV[1], val)
+return val
@@ -1,12 +1,22 @@
-val = redis.call('get', KEYS[1])
+-- KEYS[1] 就是你的分布式锁的key
+-- KEYS[2] 这个锁的 fencing token 计数器，每次加锁成功都会自增
+-- ARGV[1] 就是你预期的存在redis 里面的 value
+-- ARGV[2] 过期时间，单位毫秒
+-- 加锁成功返回 fencing token，失败返回 0
+local val = redis.call('get', KEYS[1])
 if val == false then
     --    key 不存在
-    return redis.call('set', KEYS[1], ARGV[1], 'EX', ARGV[2])
+    redis.call('set', KEYS[1], ARGV[1], 'PX', ARGV[2])
+    return redis.call('incr', KEYS[2])
 elseif val == ARGV[1] then
-    --    你上次加锁成功了
-    redis.call('expire', KEYS[1], ARGV[2])
-    return 'OK'
+    --    你上次加锁成功了，计数器当前的值就是你的 token
+    redis.call('pexpire', KEYS[1], ARGV[2])
+    local token = redis.call('get', KEYS[2])
+    if token == false then
+        token = redis.call('incr', KEYS[2])
+    end
+    return tonumber(token)
 else
 --    锁被人拿着
-    return ''
-end
\ No newline at end of file
+    return 0
+end
@@ -0,0 +1,20 @@
+-- 可重入锁使用 hash 结构：
+-- owner 字段保存持有者，其余每个字段是一次加锁的唯一 id，字段数量减一就是重入次数
+-- KEYS[1] 就是你的分布式锁的key
+-- ARGV[1] 持有者 owner
+-- ARGV[2] 本次加锁的唯一 id
+-- ARGV[3] 过期时间，单位毫秒
+local owner = redis.call('hget', KEYS[1], 'owner')
+if owner == false or owner == ARGV[1] then
+    --    key 不存在，或者本来就是你的锁
+    --    同一个 id 重复写入不会增加次数，所以超时重试也是安全的
+    redis.call('hset', KEYS[1], 'owner', ARGV[1], ARGV[2], 1)
+    --    过期时间只延长不缩短，重入的时候用了更短的过期时间，也不能让外层的锁提前过期
+    if redis.call('pttl', KEYS[1]) < tonumber(ARGV[3]) then
+        redis.call('pexpire', KEYS[1], ARGV[3])
+    end
+    return redis.call('hlen', KEYS[1]) - 1
+else
+--    锁被人拿着
+    return 0
+end
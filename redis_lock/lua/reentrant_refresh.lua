@@ -0,0 +1,16 @@
+--1. 检查是不是你的锁
+--2. 续约
+-- KEYS[1] 就是你的分布式锁的key
+-- ARGV[1] 持有者 owner
+-- ARGV[2] 加锁时的唯一 id
+-- ARGV[3] 过期时间，单位毫秒
+if redis.call('hget', KEYS[1], 'owner') == ARGV[1] and redis.call('hexists', KEYS[1], ARGV[2]) == 1 then
+    --    确实是你的锁，过期时间只延长不缩短，和加锁的时候一样
+    if redis.call('pttl', KEYS[1]) < tonumber(ARGV[3]) then
+        redis.call('pexpire', KEYS[1], ARGV[3])
+    end
+    return 1
+else
+--    不是你的锁
+    return 0
+end
@@ -0,0 +1,18 @@
+--1. 检查是不是你的锁，以及这次加锁是否还有效
+--2. 减少一次重入，次数为 0 的时候删除
+-- KEYS[1] 就是你的分布式锁的key
+-- ARGV[1] 持有者 owner
+-- ARGV[2] 加锁时的唯一 id
+if redis.call('hget', KEYS[1], 'owner') ~= ARGV[1] then
+    --    不是你的锁
+    return 0
+end
+if redis.call('hdel', KEYS[1], ARGV[2]) == 0 then
+    --    这次加锁已经释放过了
+    return 0
+end
+if redis.call('hlen', KEYS[1]) <= 1 then
+    --    没有人再持有了，只剩下 owner 字段
+    redis.call('del', KEYS[1])
+end
+return 1
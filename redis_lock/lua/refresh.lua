@@ -2,9 +2,10 @@
 --2. 删除
 -- KEYS[1] 就是你的分布式锁的key
 -- ARGV[1] 就是你预期的存在redis 里面的 value
+-- ARGV[2] 过期时间，单位毫秒
 if redis.call('get', KEYS[1]) == ARGV[1] then
     --    确实是你的锁
-    return redis.call('EXPIRE', KEYS[1], ARGV[2])
+    return redis.call('pexpire', KEYS[1], ARGV[2])
 else
 --    不是你的锁
     return 0
@@ -0,0 +1,25 @@
+-- 读锁
+-- KEYS[1] 写锁的 key
+-- KEYS[2] 读者集合，zset，score 是每个读者的过期时间（毫秒）
+-- KEYS[3] 等待中的写者集合，zset，score 是等待登记的过期时间（毫秒）
+-- ARGV[1] 读者的唯一 id
+-- ARGV[2] 过期时间，单位毫秒
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expireAt = now + tonumber(ARGV[2])
+-- 清理掉已经过期的读者和写者
+redis.call('zremrangebyscore', KEYS[2], '-inf', now)
+redis.call('zremrangebyscore', KEYS[3], '-inf', now)
+
+if redis.call('zscore', KEYS[2], ARGV[1]) == false then
+    if redis.call('exists', KEYS[1]) == 1 or redis.call('zcard', KEYS[3]) > 0 then
+        --    写锁被人拿着，或者有写者在等待，新的读者不能进来
+        return 0
+    end
+end
+--    加读锁，或者你上次加锁成功了但是返回超时了
+redis.call('zadd', KEYS[2], expireAt, ARGV[1])
+if redis.call('pttl', KEYS[2]) < tonumber(ARGV[2]) then
+    redis.call('pexpire', KEYS[2], ARGV[2])
+end
+return 1
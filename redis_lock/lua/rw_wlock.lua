@@ -0,0 +1,33 @@
+-- 写锁
+-- KEYS[1] 写锁的 key
+-- KEYS[2] 读者集合，zset，score 是每个读者的过期时间（毫秒）
+-- KEYS[3] 等待中的写者集合，zset，score 是等待登记的过期时间（毫秒）
+-- ARGV[1] 写者的唯一 id
+-- ARGV[2] 过期时间，单位毫秒
+-- ARGV[3] 抢不到锁的时候是否登记为等待中的写者，1 表示登记
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+-- 清理掉已经过期的读者和写者
+redis.call('zremrangebyscore', KEYS[2], '-inf', now)
+redis.call('zremrangebyscore', KEYS[3], '-inf', now)
+
+local val = redis.call('get', KEYS[1])
+if val == ARGV[1] then
+    --    你上次加锁成功了
+    redis.call('pexpire', KEYS[1], ARGV[2])
+    return 1
+end
+if val == false and redis.call('zcard', KEYS[2]) == 0 then
+    --    没有写者也没有读者
+    redis.call('set', KEYS[1], ARGV[1], 'PX', ARGV[2])
+    redis.call('zrem', KEYS[3], ARGV[1])
+    return 1
+end
+if ARGV[3] == '1' then
+    --    登记等待，阻止新的读者进来，避免写者饥饿
+    redis.call('zadd', KEYS[3], now + tonumber(ARGV[2]), ARGV[1])
+    if redis.call('pttl', KEYS[3]) < tonumber(ARGV[2]) then
+        redis.call('pexpire', KEYS[3], ARGV[2])
+    end
+end
+return 0
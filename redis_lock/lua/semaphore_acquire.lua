@@ -0,0 +1,20 @@
+-- 信号量，使用 zset 保存持有者，score 是每个持有者的过期时间（毫秒）
+-- KEYS[1] 信号量的 key
+-- ARGV[1] 持有者的唯一 id
+-- ARGV[2] 最多允许多少个持有者
+-- ARGV[3] 过期时间，单位毫秒
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+-- 先清理掉已经过期的持有者，崩溃的进程不会一直占着名额
+redis.call('zremrangebyscore', KEYS[1], '-inf', now)
+
+if redis.call('zscore', KEYS[1], ARGV[1]) == false and redis.call('zcard', KEYS[1]) >= tonumber(ARGV[2]) then
+    --    名额已经用完了
+    return 0
+end
+--    拿到名额，或者你上次拿到了但是返回超时了
+redis.call('zadd', KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+if redis.call('pttl', KEYS[1]) < tonumber(ARGV[3]) then
+    redis.call('pexpire', KEYS[1], ARGV[3])
+end
+return 1
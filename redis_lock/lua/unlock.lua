@@ -1,11 +1,17 @@
 --1. 检查是不是你的锁
 --2. 删除
+--3. 通知等待这把锁的人
 -- KEYS[1] 就是你的分布式锁的key
 -- ARGV[1] 就是你预期的存在redis 里面的 value
+-- ARGV[2] 释放之后要通知的 channel，可以不传；等待这把锁的 Lock 调用会订阅这个 channel
 if redis.call('get', KEYS[1]) == ARGV[1] then
     --    确实是你的锁
-    return redis.call('del', KEYS[1])
+    local res = redis.call('del', KEYS[1])
+    if ARGV[2] then
+        redis.call('publish', ARGV[2], ARGV[1])
+    end
+    return res
 else
 --    不是你的锁
     return 0
-end
\ No newline at end of file
+end
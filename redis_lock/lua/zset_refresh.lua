@@ -0,0 +1,18 @@
+-- 持有者记录在 zset 里面，score 是过期时间（毫秒），读写锁的读者和信号量都是这样存的
+--1. 检查是不是还持有
+--2. 续约
+-- KEYS[1] 持有者集合
+-- ARGV[1] 持有者的唯一 id
+-- ARGV[2] 过期时间，单位毫秒
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local score = redis.call('zscore', KEYS[1], ARGV[1])
+if score == false or tonumber(score) <= now then
+    --    已经过期了
+    return 0
+end
+redis.call('zadd', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call('pttl', KEYS[1]) < tonumber(ARGV[2]) then
+    redis.call('pexpire', KEYS[1], ARGV[2])
+end
+return 1
@@ -0,0 +1,17 @@
+-- 持有者记录在 zset 里面，score 是过期时间（毫秒），读写锁的读者和信号量都是这样存的
+--1. 检查是不是还持有，已经过期的持有者可能已经被当成空出来的名额了
+--2. 释放
+-- KEYS[1] 持有者集合
+-- ARGV[1] 持有者的唯一 id
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local score = redis.call('zscore', KEYS[1], ARGV[1])
+if score == false then
+    return 0
+end
+redis.call('zrem', KEYS[1], ARGV[1])
+if tonumber(score) <= now then
+    --    已经过期了，顺便清理掉
+    return 0
+end
+return 1
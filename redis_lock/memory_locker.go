@@ -0,0 +1,123 @@
+package redis_lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryLocker 进程内的 Locker 实现，过期语义和 Client 一样
+// 配合 FakeClock 可以在测试里面精确地控制时间
+type MemoryLocker struct {
+	mutex sync.Mutex
+	clock Clock
+	locks map[string]memoryLock
+	// 每个 key 的 fencing token 计数器
+	tokens map[string]int64
+	// 锁被释放的时候关闭，用来唤醒等待的 Lock 调用
+	released map[string]chan struct{}
+}
+
+type memoryLock struct {
+	value    string
+	expireAt time.Time
+}
+
+// NewMemoryLocker 创建进程内的 Locker，clock 为 nil 的时候使用真实的时间
+func NewMemoryLocker(clock Clock) *MemoryLocker {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &MemoryLocker{
+		clock:    clock,
+		locks:    make(map[string]memoryLock),
+		tokens:   make(map[string]int64),
+		released: make(map[string]chan struct{}),
+	}
+}
+
+// TryLock 尝试加锁，锁被人拿着的时候返回 ErrFailedToPreemptLock
+func (m *MemoryLocker) TryLock(ctx context.Context, key string, expiration time.Duration) (*Lock, error) {
+	val := newLockValue(ctx)
+	start := m.clock.Now()
+	token, _ := m.lock(key, val, expiration)
+	if token <= 0 {
+		return nil, ErrFailedToPreemptLock
+	}
+	return newLock(m, m.clock, key, val, expiration, token, start), nil
+}
+
+// Lock 加锁，锁被人拿着的时候按照重试策略进行重试
+// 等待的时候锁被释放了会马上重试。timeout 在内存实现里面没有意义
+func (m *MemoryLocker) Lock(ctx context.Context, key string, expiration time.Duration,
+	timeout time.Duration, retry RetryStrategy) (*Lock, error) {
+	val := newLockValue(ctx)
+	retry = startRetry(ctx, retry)
+	for {
+		start := m.clock.Now()
+		token, released := m.lock(key, val, expiration)
+		if token > 0 {
+			return newLock(m, m.clock, key, val, expiration, token, start), nil
+		}
+		interval, ok := retry.Next()
+		if !ok {
+			return nil, fmt.Errorf("redis-lock: 超出重试限制, %w", ErrFailedToPreemptLock)
+		}
+		select {
+		case <-m.clock.After(interval):
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lock 加锁成功返回 fencing token，
+// 失败返回 0 和一个在锁被释放的时候会关闭的 channel
+func (m *MemoryLocker) lock(key string, val string, expiration time.Duration) (int64, <-chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.clock.Now()
+	if l, ok := m.locks[key]; ok && now.Before(l.expireAt) {
+		ch, ok := m.released[key]
+		if !ok {
+			ch = make(chan struct{})
+			m.released[key] = ch
+		}
+		return 0, ch
+	}
+	m.locks[key] = memoryLock{value: val, expireAt: now.Add(expiration)}
+	m.tokens[key]++
+	return m.tokens[key], nil
+}
+
+// Refresh 续约
+func (m *MemoryLocker) Refresh(ctx context.Context, l *Lock) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.clock.Now()
+	ml, ok := m.locks[l.key]
+	if !ok || ml.value != l.value || !now.Before(ml.expireAt) {
+		return ErrLockNotHold
+	}
+	ml.expireAt = now.Add(l.expiration)
+	m.locks[l.key] = ml
+	return nil
+}
+
+// Unlock 释放锁
+func (m *MemoryLocker) Unlock(ctx context.Context, l *Lock) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ml, ok := m.locks[l.key]
+	if !ok || ml.value != l.value || !m.clock.Now().Before(ml.expireAt) {
+		return ErrLockNotHold
+	}
+	delete(m.locks, l.key)
+	if ch, ok := m.released[l.key]; ok {
+		close(ch)
+		delete(m.released, l.key)
+	}
+	return nil
+}
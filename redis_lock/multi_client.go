@@ -0,0 +1,239 @@
+package redis_lock
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MultiClient 在多个相互独立的 redis 节点上加锁（Redlock）
+// 只有在多数节点上加锁成功，并且加锁耗时没有用完锁的有效期，才算加锁成功。
+// 这样单个 redis 节点故障或者主从切换，不会把同一把锁交给两个人。
+// MultiClient 目前不会给 Observer 发事件
+type MultiClient struct {
+	clients []redis.Cmdable
+	// 时钟漂移系数，有效期会扣掉 expiration * driftFactor
+	driftFactor float64
+}
+
+func NewMultiClient(clients ...redis.Cmdable) *MultiClient {
+	return &MultiClient{
+		clients:     clients,
+		driftFactor: 0.01,
+	}
+}
+
+// MultiLock 在多个节点上持有的锁
+type MultiLock struct {
+	clients    []redis.Cmdable
+	key        string
+	value      string
+	expiration time.Duration
+	// 单个节点的超时时间，续约和释放也用这个超时时间
+	timeout time.Duration
+	// 时钟漂移，计算有效期的时候要扣掉
+	drift time.Duration
+	// 锁的有效期截止的时间（UnixNano），续约成功之后会更新
+	validUntil atomic.Int64
+	unlockChan chan struct{}
+}
+
+// quorum 多数派的数量
+func quorum(n int) int {
+	return n/2 + 1
+}
+
+// tryLockTimeoutRatio TryLock 在单个节点上的超时时间占 expiration 的比例
+const tryLockTimeoutRatio = 10
+
+// TryLock 尝试在多数节点上加锁，失败的时候会在所有节点上释放已经加上的锁
+// 单个节点的超时时间是 expiration 的 1/10，一个节点卡住不会拖住整个调用，也不会耗掉太多有效期
+func (c *MultiClient) TryLock(ctx context.Context, key string, expiration time.Duration) (*MultiLock, error) {
+	val := uuid.New().String()
+	timeout := expiration / tryLockTimeoutRatio
+	validUntil, ok := c.lockOnce(ctx, key, val, expiration, timeout)
+	if !ok {
+		return nil, ErrFailedToPreemptLock
+	}
+	return c.newMultiLock(key, val, expiration, timeout, validUntil), nil
+}
+
+// Lock 在多数节点上加锁，失败的时候按照重试策略进行重试
+// timeout: 在单个节点上加锁的超时时间，应该远小于 expiration
+func (c *MultiClient) Lock(ctx context.Context, key string, expiration time.Duration,
+	timeout time.Duration, retry RetryStrategy) (*MultiLock, error) {
+	val := uuid.New().String()
+	var validUntil time.Time
+	err := retryLock(ctx, 0, retry, func(ctx context.Context) (bool, error) {
+		var ok bool
+		validUntil, ok = c.lockOnce(ctx, key, val, expiration, timeout)
+		return ok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.newMultiLock(key, val, expiration, timeout, validUntil), nil
+}
+
+// lockOnce 在所有节点上加一次锁，返回锁的有效期截止的时间
+func (c *MultiClient) lockOnce(ctx context.Context, key string, val string,
+	expiration time.Duration, timeout time.Duration) (time.Time, bool) {
+	start := time.Now()
+	res := eachClient(ctx, c.clients, timeout, func(ctx context.Context, client redis.Cmdable) (bool, error) {
+		token, err := client.Eval(ctx, luaLock, []string{key, fencingKey(key)}, val, expiration.Milliseconds()).Int64()
+		return token > 0, err
+	})
+	validUntil := start.Add(expiration - c.drift(expiration))
+	if res.succeeded >= quorum(len(c.clients)) && time.Now().Before(validUntil) {
+		return validUntil, true
+	}
+	// 没有拿到多数派，或者锁已经没有有效时间了，在所有节点上释放
+	cleanup(timeout, func(ctx context.Context) {
+		eachClient(ctx, c.clients, timeout, func(ctx context.Context, client redis.Cmdable) (bool, error) {
+			res, err := client.Eval(ctx, luaUnlock, []string{key}, val).Int64()
+			return res == 1, err
+		})
+	})
+	return time.Time{}, false
+}
+
+// drift 时钟漂移，有效期要扣掉这部分
+func (c *MultiClient) drift(expiration time.Duration) time.Duration {
+	return time.Duration(float64(expiration)*c.driftFactor) + 2*time.Millisecond
+}
+
+func (c *MultiClient) newMultiLock(key string, val string, expiration time.Duration,
+	timeout time.Duration, validUntil time.Time) *MultiLock {
+	l := &MultiLock{
+		clients:    c.clients,
+		key:        key,
+		value:      val,
+		expiration: expiration,
+		timeout:    timeout,
+		drift:      c.drift(expiration),
+		unlockChan: make(chan struct{}, 1),
+	}
+	l.validUntil.Store(validUntil.UnixNano())
+	return l
+}
+
+// Validity 锁剩余的有效时间，业务应该在这个时间之内完成，或者及时续约
+// 每次续约成功之后会重新计算；小于等于 0 说明多数节点上的锁可能已经过期了，不能再认为自己持有锁
+func (l *MultiLock) Validity() time.Duration {
+	return time.Until(time.Unix(0, l.validUntil.Load()))
+}
+
+// Refresh 在所有节点上续约，多数节点续约成功才算成功，成功之后会重新计算 Validity
+// 续约本身耗时太久，用完了有效期的时候返回 ErrLockNotHold
+func (l *MultiLock) Refresh(ctx context.Context) error {
+	start := time.Now()
+	res := eachClient(ctx, l.clients, l.timeout, func(ctx context.Context, client redis.Cmdable) (bool, error) {
+		res, err := client.Eval(ctx, luaRefresh, []string{l.key}, l.value, l.expiration.Milliseconds()).Int64()
+		return res == 1, err
+	})
+	if err := res.quorumErr(len(l.clients)); err != nil {
+		return err
+	}
+	validUntil := start.Add(l.expiration - l.drift)
+	if !time.Now().Before(validUntil) {
+		return ErrLockNotHold
+	}
+	l.validUntil.Store(validUntil.UnixNano())
+	return nil
+}
+
+// AutoRefresh 自动续约，用法和 Lock.AutoRefresh 一样
+func (l *MultiLock) AutoRefresh(interval time.Duration, timeout time.Duration) error {
+	return autoRefresh(realClock{}, l.Refresh, l.unlockChan, interval, timeout, func() time.Time {
+		return time.Unix(0, l.validUntil.Load())
+	})
+}
+
+// Unlock 在所有节点上释放锁，多数节点释放成功才算成功
+func (l *MultiLock) Unlock(ctx context.Context) error {
+	res := eachClient(ctx, l.clients, l.timeout, func(ctx context.Context, client redis.Cmdable) (bool, error) {
+		res, err := client.Eval(ctx, luaUnlock, []string{l.key}, l.value).Int64()
+		return res == 1, err
+	})
+	defer notifyUnlocked(l.unlockChan)
+	return res.quorumErr(len(l.clients))
+}
+
+// multiResult 在多个节点上执行的结果
+type multiResult struct {
+	// 返回 true 的节点数量
+	succeeded int
+	// 返回 false 的节点数量
+	failed int
+	errs   []error
+}
+
+// quorumErr 多数节点成功返回 nil；
+// 已经不可能凑够多数派的时候返回 ErrLockNotHold，否则返回各个节点的错误
+func (r multiResult) quorumErr(n int) error {
+	if r.succeeded >= quorum(n) {
+		return nil
+	}
+	if r.failed > n-quorum(n) || len(r.errs) == 0 {
+		return ErrLockNotHold
+	}
+	return errors.Join(r.errs...)
+}
+
+// eachClient 并发地在每个节点上执行 fn，timeout 大于 0 的时候是单个节点的超时时间
+func eachClient(ctx context.Context, clients []redis.Cmdable, timeout time.Duration,
+	fn func(ctx context.Context, client redis.Cmdable) (bool, error)) multiResult {
+	var res multiResult
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client redis.Cmdable) {
+			defer wg.Done()
+			cctx, cancel := ctx, context.CancelFunc(func() {})
+			if timeout > 0 {
+				cctx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			ok, err := callNode(cctx, client, fn)
+			cancel()
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case err != nil:
+				res.errs = append(res.errs, err)
+			case ok:
+				res.succeeded++
+			default:
+				res.failed++
+			}
+		}(client)
+	}
+	wg.Wait()
+	return res
+}
+
+// callNode 在单个节点上执行 fn，ctx 结束之后不再等待结果
+// go-redis 默认不会用 ctx 的 deadline 作为网络超时（参考 redis.Options.ContextTimeoutEnabled），
+// 一个卡住的节点会一直等到 ReadTimeout，所以这里要自己处理超时
+func callNode(ctx context.Context, client redis.Cmdable,
+	fn func(ctx context.Context, client redis.Cmdable) (bool, error)) (bool, error) {
+	type result struct {
+		ok  bool
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		ok, err := fn(ctx, client)
+		ch <- result{ok: ok, err: err}
+	}()
+	select {
+	case res := <-ch:
+		return res.ok, res.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
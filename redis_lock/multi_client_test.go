@@ -0,0 +1,176 @@
+package redis_lock
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMultiClientForTest 启动 n 个 miniredis，前 failing 个节点的所有命令都会失败
+func newMultiClientForTest(t *testing.T, n int, failing int) (*MultiClient, []*miniredis.Miniredis) {
+	servers := make([]*miniredis.Miniredis, 0, n)
+	clients := make([]redis.Cmdable, 0, n)
+	for i := 0; i < n; i++ {
+		c, mr := newClientForTest(t)
+		if i < failing {
+			mr.SetError("节点故障")
+		}
+		servers = append(servers, mr)
+		clients = append(clients, c.client)
+	}
+	return NewMultiClient(clients...), servers
+}
+
+func TestMultiClient_TryLock(t *testing.T) {
+	testCases := []struct {
+		name    string
+		n       int
+		failing int
+		// 提前被别人拿走锁的节点
+		taken   int
+		wantErr error
+	}{
+		{name: "全部节点正常", n: 3},
+		{name: "少数节点故障", n: 5, failing: 2},
+		{name: "多数节点故障", n: 3, failing: 2, wantErr: ErrFailedToPreemptLock},
+		{name: "多数节点被别人持有", n: 5, taken: 3, wantErr: ErrFailedToPreemptLock},
+		{name: "少数节点被别人持有", n: 5, failing: 1, taken: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, servers := newMultiClientForTest(t, tc.n, tc.failing)
+			for i := tc.failing; i < tc.failing+tc.taken; i++ {
+				require.NoError(t, servers[i].Set("key1", "other"))
+			}
+			l, err := client.TryLock(context.Background(), "key1", time.Minute)
+			assert.True(t, errors.Is(err, tc.wantErr))
+			if err != nil {
+				// 失败的时候不能在任何节点上留下自己的锁
+				for i := tc.failing; i < tc.n; i++ {
+					val, _ := servers[i].Get("key1")
+					if i < tc.failing+tc.taken {
+						assert.Equal(t, "other", val)
+					} else {
+						assert.False(t, servers[i].Exists("key1"))
+					}
+				}
+				return
+			}
+			assert.True(t, l.Validity() > 0 && l.Validity() < time.Minute)
+			for i := tc.failing + tc.taken; i < tc.n; i++ {
+				val, err := servers[i].Get("key1")
+				require.NoError(t, err)
+				assert.Equal(t, l.value, val)
+			}
+		})
+	}
+}
+
+func TestMultiClient_Lock(t *testing.T) {
+	client, servers := newMultiClientForTest(t, 3, 1)
+	require.NoError(t, servers[1].Set("key1", "other"))
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		servers[1].Del("key1")
+	}()
+	l, err := client.Lock(context.Background(), "key1", time.Minute, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond * 20, MaxCnt: 10})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, l.expiration)
+}
+
+func TestMultiLock_RefreshAndUnlock(t *testing.T) {
+	client, servers := newMultiClientForTest(t, 3, 0)
+	ctx := context.Background()
+	l, err := client.TryLock(ctx, "key1", time.Minute)
+	require.NoError(t, err)
+
+	// 一个节点故障，还能续约和释放
+	servers[0].SetError("节点故障")
+	require.NoError(t, l.Refresh(ctx))
+	require.NoError(t, l.Unlock(ctx))
+	assert.False(t, servers[1].Exists("key1"))
+	assert.False(t, servers[2].Exists("key1"))
+
+	// 多数节点上的锁已经没了
+	servers[0].SetError("")
+	l, err = client.TryLock(ctx, "key1", time.Minute)
+	require.NoError(t, err)
+	servers[0].Del("key1")
+	servers[1].Del("key1")
+	assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+	assert.Equal(t, ErrLockNotHold, l.Unlock(ctx))
+}
+
+// newHungClientForTest 连接到一个只接收连接、从不回复的节点
+func newHungClientForTest(t *testing.T) redis.Cmdable {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var mutex sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, conn)
+			mutex.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	return redis.NewClient(&redis.Options{Addr: ln.Addr().String(), MaxRetries: -1})
+}
+
+func TestMultiClient_HungNode(t *testing.T) {
+	c1, _ := newClientForTest(t)
+	c2, _ := newClientForTest(t)
+	client := NewMultiClient(c1.client, c2.client, newHungClientForTest(t))
+	ctx := context.Background()
+
+	// ctx 没有 deadline，卡住的节点也不能拖住 TryLock
+	start := time.Now()
+	l, err := client.TryLock(ctx, "key1", time.Second)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+	assert.True(t, l.Validity() > 0)
+
+	start = time.Now()
+	require.NoError(t, l.Refresh(ctx))
+	require.NoError(t, l.Unlock(ctx))
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+}
+
+func TestMultiLock_Validity(t *testing.T) {
+	client, _ := newMultiClientForTest(t, 3, 0)
+	ctx := context.Background()
+	l, err := client.TryLock(ctx, "key1", time.Millisecond*200)
+	require.NoError(t, err)
+	validity := l.Validity()
+	assert.True(t, validity > 0 && validity < time.Millisecond*200)
+
+	// 有效期随着时间减少，续约之后重新计算
+	time.Sleep(time.Millisecond * 100)
+	assert.Less(t, l.Validity(), validity-time.Millisecond*50)
+	require.NoError(t, l.Refresh(ctx))
+	assert.Greater(t, l.Validity(), validity-time.Millisecond*50)
+
+	// 不续约的话有效期会用完
+	time.Sleep(time.Millisecond * 250)
+	assert.True(t, l.Validity() <= 0)
+}
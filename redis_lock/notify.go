@@ -0,0 +1,157 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+// subscribeTimeout 等待订阅确认的最长时间，超时之后退化为轮询
+const subscribeTimeout = time.Second
+
+// subscriber 支持订阅的客户端，比如 *redis.Client 和 *redis.ClusterClient
+// redis.Cmdable 本身不包含订阅，所以只能在运行时判断
+type subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// unlockChannel 锁被释放之后，会往这个 channel 发布一条消息
+func unlockChannel(key string) string {
+	return "redis-lock:unlock:" + key
+}
+
+// subscribeFunc 订阅释放通知，返回 nil channel 的时候说明没有订阅上，第二个返回值用来取消订阅
+type subscribeFunc func(ctx context.Context) (<-chan *redis.Message, func())
+
+// unlockSubscriber 订阅 key 的释放通知，给 retryLockWithWakeup 用
+func (c *Client) unlockSubscriber(key string) subscribeFunc {
+	return func(ctx context.Context) (<-chan *redis.Message, func()) {
+		return c.unlocks.subscribe(ctx, c.client, unlockChannel(key))
+	}
+}
+
+// unlockHub 同一个 Client 上所有等待者共用一个 PubSub，也就是只占用一个连接
+// 有人等待的时候才建立 PubSub，channel 按照等待者的数量订阅和退订，
+// 收到的通知再分发给等待这个 channel 的所有等待者。
+// 最后一个等待者离开之后关闭 PubSub
+type unlockHub struct {
+	mutex    sync.Mutex
+	ps       *redis.PubSub
+	channels map[string]*unlockChannelState
+}
+
+type unlockChannelState struct {
+	// 收到订阅确认的时候关闭
+	ready   chan struct{}
+	waiters map[chan *redis.Message]struct{}
+}
+
+// subscribe 订阅 channel，等到订阅确认之后才返回，保证不会错过之后的通知
+// 客户端不支持订阅、订阅失败或者迟迟收不到确认的时候返回 nil channel，调用方退化为按照重试策略轮询
+func (h *unlockHub) subscribe(ctx context.Context, client redis.Cmdable, channel string) (<-chan *redis.Message, func()) {
+	sub, ok := client.(subscriber)
+	if !ok {
+		return nil, func() {}
+	}
+	msgs := make(chan *redis.Message, 1)
+	h.mutex.Lock()
+	state, ok := h.channels[channel]
+	if !ok {
+		state = &unlockChannelState{
+			ready:   make(chan struct{}),
+			waiters: make(map[chan *redis.Message]struct{}),
+		}
+		var err error
+		if h.ps == nil {
+			h.ps = sub.Subscribe(ctx, channel)
+			h.channels = make(map[string]*unlockChannelState)
+			go h.dispatch(h.ps, h.ps.ChannelWithSubscriptions())
+		} else {
+			err = h.ps.Subscribe(ctx, channel)
+		}
+		if err != nil {
+			h.closeIfIdle()
+			h.mutex.Unlock()
+			return nil, func() {}
+		}
+		h.channels[channel] = state
+	}
+	state.waiters[msgs] = struct{}{}
+	h.mutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.unsubscribe(channel, msgs)
+		})
+	}
+	timer := time.NewTimer(subscribeTimeout)
+	defer timer.Stop()
+	select {
+	case <-state.ready:
+		return msgs, unsubscribe
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	unsubscribe()
+	return nil, func() {}
+}
+
+// unsubscribe 移除等待者并且关闭它的 channel，channel 上没有等待者之后退订
+func (h *unlockHub) unsubscribe(channel string, msgs chan *redis.Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	state := h.channels[channel]
+	delete(state.waiters, msgs)
+	close(msgs)
+	if len(state.waiters) > 0 {
+		return
+	}
+	delete(h.channels, channel)
+	if !h.closeIfIdle() {
+		_ = h.ps.Unsubscribe(context.Background(), channel)
+	}
+}
+
+// closeIfIdle 没有等待者的时候关闭 PubSub，需要持有锁
+func (h *unlockHub) closeIfIdle() bool {
+	if len(h.channels) > 0 {
+		return false
+	}
+	_ = h.ps.Close()
+	h.ps = nil
+	h.channels = nil
+	return true
+}
+
+// dispatch 把 ps 收到的订阅确认和释放通知分发给等待者，ps 被关闭的时候结束
+// ps 已经被换掉的时候丢掉收到的消息
+func (h *unlockHub) dispatch(ps *redis.PubSub, ch <-chan interface{}) {
+	for msg := range ch {
+		h.mutex.Lock()
+		if h.ps == ps {
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				if state, ok := h.channels[msg.Channel]; ok && msg.Kind == "subscribe" {
+					select {
+					case <-state.ready:
+					default:
+						close(state.ready)
+					}
+				}
+			case *redis.Message:
+				if state, ok := h.channels[msg.Channel]; ok {
+					for waiter := range state.waiters {
+						// 等待的人只关心有没有锁被释放，已经有通知的时候丢掉新的通知
+						select {
+						case waiter <- msg:
+						default:
+						}
+					}
+				}
+			}
+		}
+		h.mutex.Unlock()
+	}
+}
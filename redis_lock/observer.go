@@ -0,0 +1,307 @@
+package redis_lock
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventType 加锁过程中的事件类型
+type EventType int
+
+const (
+	// EventAcquireAttempt 每一次尝试加锁，Duration 是这次尝试的耗时，
+	// 没有拿到锁的时候 Err 是 ErrFailedToPreemptLock 或者 redis 返回的错误
+	EventAcquireAttempt EventType = iota
+	// EventAcquired 加锁成功，Duration 是从开始加锁到拿到锁的等待时间
+	EventAcquired
+	// EventRetry 准备重试，Duration 是重试间隔
+	EventRetry
+	// EventRefresh 续约成功，Duration 是续约的耗时
+	EventRefresh
+	// EventRefreshFailed 续约失败，Err 是失败的原因
+	EventRefreshFailed
+	// EventUnlock 释放锁，Duration 是释放的耗时，释放失败的时候 Err 不为 nil
+	EventUnlock
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventAcquireAttempt:
+		return "acquire_attempt"
+	case EventAcquired:
+		return "acquired"
+	case EventRetry:
+		return "retry"
+	case EventRefresh:
+		return "refresh"
+	case EventRefreshFailed:
+		return "refresh_failed"
+	case EventUnlock:
+		return "unlock"
+	default:
+		return "unknown"
+	}
+}
+
+// Event 加锁过程中的事件
+type Event struct {
+	Type     EventType
+	Key      string
+	Duration time.Duration
+	Err      error
+}
+
+// Observer 观察加锁过程中的事件，可以用来做监控和链路追踪
+// Observe 是在加锁的调用链路上同步执行的，不要在里面做耗时的操作。
+// ctx 是调用方传进来的 context，可以从里面拿到 trace 之类的信息；
+// 自动续约的时候没有调用方的 context，这时候是 context.Background 加上续约的超时时间。
+// Client 上的锁（Lock、TryLock、FairLock、读写锁和可重入锁）都会发出事件，
+// Semaphore 和 MultiClient 目前还不会
+type Observer interface {
+	Observe(ctx context.Context, e Event)
+}
+
+// ObserverFunc 让普通的函数也能作为 Observer
+type ObserverFunc func(ctx context.Context, e Event)
+
+func (f ObserverFunc) Observe(ctx context.Context, e Event) {
+	f(ctx, e)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) Observe(context.Context, Event) {}
+
+// ClientOption Client 的可选配置
+type ClientOption func(c *Client)
+
+// WithObserver 设置 Observer，多次调用的时候每个 Observer 都会收到事件
+func WithObserver(o Observer) ClientOption {
+	return func(c *Client) {
+		if _, ok := c.observer.(noopObserver); ok {
+			c.observer = o
+			return
+		}
+		prev := c.observer
+		c.observer = ObserverFunc(func(ctx context.Context, e Event) {
+			prev.Observe(ctx, e)
+			o.Observe(ctx, e)
+		})
+	}
+}
+
+func (c *Client) observe(ctx context.Context, typ EventType, key string, d time.Duration, err error) {
+	observe(ctx, c.observer, typ, key, d, err)
+}
+
+func observe(ctx context.Context, o Observer, typ EventType, key string, d time.Duration, err error) {
+	o.Observe(ctx, Event{Type: typ, Key: key, Duration: d, Err: err})
+}
+
+// observeAttempt ok 表示这次尝试拿到了锁
+func (c *Client) observeAttempt(ctx context.Context, key string, d time.Duration, ok bool, err error) {
+	if err == nil && !ok {
+		err = ErrFailedToPreemptLock
+	}
+	c.observe(ctx, EventAcquireAttempt, key, d, err)
+}
+
+// observeRefresh 续约之后发出 EventRefresh 或者 EventRefreshFailed
+func observeRefresh(ctx context.Context, o Observer, key string, d time.Duration, err error) {
+	if err != nil {
+		observe(ctx, o, EventRefreshFailed, key, d, err)
+	} else {
+		observe(ctx, o, EventRefresh, key, d, nil)
+	}
+}
+
+// observeRetry 包装重试策略，每次决定重试的时候发出 EventRetry
+func (c *Client) observeRetry(ctx context.Context, key string, retry RetryStrategy) RetryStrategy {
+	return &observedRetry{
+		RetryStrategy: startRetry(ctx, retry),
+		observe: func(interval time.Duration) {
+			c.observe(ctx, EventRetry, key, interval, nil)
+		},
+	}
+}
+
+type observedRetry struct {
+	RetryStrategy
+	observe func(interval time.Duration)
+}
+
+func (r *observedRetry) Next() (time.Duration, bool) {
+	interval, ok := r.RetryStrategy.Next()
+	if ok {
+		r.observe(interval)
+	}
+	return interval, ok
+}
+
+// MetricsRecorder 指标的记录方式，可以用 prometheus 之类的库来实现
+// 比如 IncCounter 对应 CounterVec.WithLabelValues(key).Inc()，
+// ObserveDuration 对应 HistogramVec.WithLabelValues(key).Observe(d.Seconds())。
+// 注意 key 的数量很多的时候，作为标签会导致指标膨胀
+type MetricsRecorder interface {
+	// IncCounter 计数器加一
+	IncCounter(name string, key string)
+	// ObserveDuration 记录一次耗时，一般用直方图来实现
+	ObserveDuration(name string, key string, d time.Duration)
+}
+
+// 指标的名字
+const (
+	MetricAcquireAttempts = "redis_lock_acquire_attempts_total"
+	MetricAcquireFailures = "redis_lock_acquire_failures_total"
+	MetricAcquired        = "redis_lock_acquired_total"
+	MetricRetries         = "redis_lock_retries_total"
+	MetricRefreshes       = "redis_lock_refreshes_total"
+	MetricRefreshFailures = "redis_lock_refresh_failures_total"
+	MetricUnlocks         = "redis_lock_unlocks_total"
+	MetricUnlockFailures  = "redis_lock_unlock_failures_total"
+
+	MetricWaitDuration    = "redis_lock_wait_duration"
+	MetricAttemptDuration = "redis_lock_attempt_duration"
+	MetricRefreshDuration = "redis_lock_refresh_duration"
+	MetricUnlockDuration  = "redis_lock_unlock_duration"
+)
+
+// NewMetricsObserver 把事件转换成计数器和直方图
+func NewMetricsObserver(r MetricsRecorder) Observer {
+	return ObserverFunc(func(_ context.Context, e Event) {
+		switch e.Type {
+		case EventAcquireAttempt:
+			r.IncCounter(MetricAcquireAttempts, e.Key)
+			r.ObserveDuration(MetricAttemptDuration, e.Key, e.Duration)
+			if e.Err != nil {
+				r.IncCounter(MetricAcquireFailures, e.Key)
+			}
+		case EventAcquired:
+			r.IncCounter(MetricAcquired, e.Key)
+			r.ObserveDuration(MetricWaitDuration, e.Key, e.Duration)
+		case EventRetry:
+			r.IncCounter(MetricRetries, e.Key)
+		case EventRefresh:
+			r.IncCounter(MetricRefreshes, e.Key)
+			r.ObserveDuration(MetricRefreshDuration, e.Key, e.Duration)
+		case EventRefreshFailed:
+			r.IncCounter(MetricRefreshFailures, e.Key)
+		case EventUnlock:
+			r.IncCounter(MetricUnlocks, e.Key)
+			r.ObserveDuration(MetricUnlockDuration, e.Key, e.Duration)
+			if e.Err != nil {
+				r.IncCounter(MetricUnlockFailures, e.Key)
+			}
+		}
+	})
+}
+
+// DefaultBuckets 直方图默认的桶
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// Metrics 进程内的 MetricsRecorder 实现
+// 实现了 expvar.Var，可以直接 expvar.Publish("redis_lock", metrics)
+type Metrics struct {
+	mutex      sync.RWMutex
+	buckets    []time.Duration
+	counters   map[string]map[string]int64
+	histograms map[string]map[string]*Histogram
+}
+
+// Histogram 直方图
+type Histogram struct {
+	// Buckets 桶的上界，从小到大
+	Buckets []time.Duration `json:"buckets"`
+	// Counts Counts[i] 是小于等于 Buckets[i] 的数量，最后一个元素是超过所有桶的数量
+	Counts []int64       `json:"counts"`
+	Count  int64         `json:"count"`
+	Sum    time.Duration `json:"sum"`
+}
+
+// NewMetrics 创建进程内的指标，buckets 为空的时候使用 DefaultBuckets
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i] < buckets[j]
+	})
+	return &Metrics{
+		buckets:    buckets,
+		counters:   make(map[string]map[string]int64),
+		histograms: make(map[string]map[string]*Histogram),
+	}
+}
+
+func (m *Metrics) IncCounter(name string, key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	counters, ok := m.counters[name]
+	if !ok {
+		counters = make(map[string]int64)
+		m.counters[name] = counters
+	}
+	counters[key]++
+}
+
+func (m *Metrics) ObserveDuration(name string, key string, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	histograms, ok := m.histograms[name]
+	if !ok {
+		histograms = make(map[string]*Histogram)
+		m.histograms[name] = histograms
+	}
+	h, ok := histograms[key]
+	if !ok {
+		h = &Histogram{
+			Buckets: m.buckets,
+			Counts:  make([]int64, len(m.buckets)+1),
+		}
+		histograms[key] = h
+	}
+	idx := sort.Search(len(h.Buckets), func(i int) bool {
+		return d <= h.Buckets[i]
+	})
+	h.Counts[idx]++
+	h.Count++
+	h.Sum += d
+}
+
+// Counter 返回计数器的值
+func (m *Metrics) Counter(name string, key string) int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.counters[name][key]
+}
+
+// Histogram 返回直方图的副本，没有数据的时候返回 nil
+func (m *Metrics) Histogram(name string, key string) *Histogram {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	h, ok := m.histograms[name][key]
+	if !ok {
+		return nil
+	}
+	res := *h
+	res.Counts = append([]int64(nil), h.Counts...)
+	return &res
+}
+
+// String 以 JSON 的格式输出所有的指标，实现 expvar.Var
+func (m *Metrics) String() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	data, _ := json.Marshal(map[string]any{
+		"counters":   m.counters,
+		"histograms": m.histograms,
+	})
+	return string(data)
+}
@@ -0,0 +1,150 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Observer(t *testing.T) {
+	metrics := NewMetrics()
+	var events []EventType
+	ctx := WithLabel(context.Background(), "trace-1")
+	c, _ := newClientForTest(t,
+		WithObserver(NewMetricsObserver(metrics)),
+		WithObserver(ObserverFunc(func(octx context.Context, e Event) {
+			// 调用方的 context 会传给 Observer
+			assert.Equal(t, "trace-1", labelFrom(octx))
+			events = append(events, e.Type)
+		})))
+
+	l, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	_, err = c.Lock(ctx, "key1", time.Second*10, time.Second, &countRetry{interval: time.Millisecond, cnt: 2})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+	require.NoError(t, l.Refresh(ctx))
+	require.NoError(t, l.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+
+	assert.Equal(t, []EventType{
+		EventAcquireAttempt, EventAcquired,
+		// 第一次失败之后订阅释放通知，订阅上之后马上再试一次
+		EventAcquireAttempt, EventAcquireAttempt, EventRetry, EventAcquireAttempt, EventRetry, EventAcquireAttempt,
+		EventRefresh, EventUnlock, EventRefreshFailed,
+	}, events)
+
+	assert.Equal(t, int64(5), metrics.Counter(MetricAcquireAttempts, "key1"))
+	assert.Equal(t, int64(4), metrics.Counter(MetricAcquireFailures, "key1"))
+	assert.Equal(t, int64(1), metrics.Counter(MetricAcquired, "key1"))
+	assert.Equal(t, int64(2), metrics.Counter(MetricRetries, "key1"))
+	assert.Equal(t, int64(1), metrics.Counter(MetricRefreshes, "key1"))
+	assert.Equal(t, int64(1), metrics.Counter(MetricRefreshFailures, "key1"))
+	assert.Equal(t, int64(1), metrics.Counter(MetricUnlocks, "key1"))
+	assert.Equal(t, int64(0), metrics.Counter(MetricUnlockFailures, "key1"))
+
+	h := metrics.Histogram(MetricAttemptDuration, "key1")
+	require.NotNil(t, h)
+	assert.Equal(t, int64(5), h.Count)
+	assert.Nil(t, metrics.Histogram(MetricAttemptDuration, "key2"))
+	assert.Contains(t, metrics.String(), MetricAcquireAttempts)
+}
+
+// eventRecorder 把收到的事件都记下来，可以在多个 goroutine 里面使用
+type eventRecorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Observe(_ context.Context, e Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+}
+
+// types 返回 key 上发生过的事件类型
+func (r *eventRecorder) types(key string) []EventType {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var res []EventType
+	for _, e := range r.events {
+		if e.Key == key {
+			res = append(res, e.Type)
+		}
+	}
+	return res
+}
+
+func (r *eventRecorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = nil
+}
+
+func TestClient_ObserverFairLock(t *testing.T) {
+	events := &eventRecorder{}
+	c, _ := newClientForTest(t, WithObserver(events))
+	ctx := context.Background()
+
+	_, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	events.reset()
+	_, err = c.FairLock(ctx, "key1", time.Second*10, time.Second, &countRetry{interval: time.Millisecond, cnt: 1})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+	assert.Equal(t, []EventType{
+		EventAcquireAttempt, EventAcquireAttempt, EventRetry, EventAcquireAttempt,
+	}, events.types("key1"))
+}
+
+func TestClient_ObserverRWLock(t *testing.T) {
+	events := &eventRecorder{}
+	c, _ := newClientForTest(t, WithObserver(events))
+	ctx := context.Background()
+
+	r, err := c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	_, err = c.WLock(ctx, "key1", time.Second*10, time.Second, &countRetry{interval: time.Millisecond, cnt: 1})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+	require.NoError(t, r.Refresh(ctx))
+	require.NoError(t, r.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, r.Refresh(ctx))
+	w, err := c.WLock(ctx, "key1", time.Second*10, time.Second, &countRetry{interval: time.Millisecond, cnt: 1})
+	require.NoError(t, err)
+	require.NoError(t, w.Unlock(ctx))
+
+	assert.Equal(t, []EventType{
+		EventAcquireAttempt, EventAcquired,
+		EventAcquireAttempt, EventRetry, EventAcquireAttempt,
+		EventRefresh, EventUnlock, EventRefreshFailed,
+		EventAcquireAttempt, EventAcquired, EventUnlock,
+	}, events.types("key1"))
+}
+
+func TestClient_ObserverReentrantLock(t *testing.T) {
+	events := &eventRecorder{}
+	c, _ := newClientForTest(t, WithObserver(events))
+	ctx := context.Background()
+
+	_, err := c.TryLockReentrant(ctx, "key1", NewOwner(), time.Second*10)
+	require.NoError(t, err)
+	_, err = c.LockReentrant(ctx, "key1", NewOwner(), time.Second*10, time.Second,
+		&countRetry{interval: time.Millisecond, cnt: 1})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+	owner := NewOwner()
+	l, err := c.LockReentrant(ctx, "key2", owner, time.Second*10, time.Second,
+		&countRetry{interval: time.Millisecond, cnt: 1})
+	require.NoError(t, err)
+	require.NoError(t, l.Refresh(ctx))
+	require.NoError(t, l.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, l.Refresh(ctx))
+
+	assert.Equal(t, []EventType{
+		EventAcquireAttempt, EventAcquired,
+		EventAcquireAttempt, EventRetry, EventAcquireAttempt,
+	}, events.types("key1"))
+	assert.Equal(t, []EventType{
+		EventAcquireAttempt, EventAcquired, EventRefresh, EventUnlock, EventRefreshFailed,
+	}, events.types("key2"))
+}
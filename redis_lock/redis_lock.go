@@ -5,15 +5,19 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
+	"github.com/colin-water/go_tool_libaray/base/retry"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/sync/singleflight"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrFailedToPreemptLock = errors.New("redis-lock: 抢锁失败")
 	ErrLockNotHold         = errors.New("redis-lock: 你没有持有锁")
+	ErrLockReleased        = errors.New("redis-lock: 锁已经释放")
 
 	//go:embed lua/unlock.lua
 	luaUnlock string
@@ -23,6 +27,45 @@ var (
 
 	//go:embed lua/lock.lua
 	luaLock string
+
+	//go:embed lua/reentrant_lock.lua
+	luaReentrantLock string
+
+	//go:embed lua/reentrant_unlock.lua
+	luaReentrantUnlock string
+
+	//go:embed lua/reentrant_refresh.lua
+	luaReentrantRefresh string
+
+	//go:embed lua/rw_rlock.lua
+	luaRWRLock string
+
+	//go:embed lua/rw_wlock.lua
+	luaRWWLock string
+
+	//go:embed lua/zset_refresh.lua
+	luaZSetRefresh string
+
+	//go:embed lua/zset_release.lua
+	luaZSetRelease string
+
+	//go:embed lua/semaphore_acquire.lua
+	luaSemaphoreAcquire string
+
+	//go:embed lua/fair_lock.lua
+	luaFairLock string
+
+	//go:embed lua/fair_cancel.lua
+	luaFairCancel string
+
+	//go:embed lua/fair_heartbeat.lua
+	luaFairHeartbeat string
+
+	//go:embed lua/check.lua
+	luaCheck string
+
+	//go:embed lua/force_unlock.lua
+	luaForceUnlock string
 )
 
 // Client 就是对 redis.Cmdable 的二次封装
@@ -32,20 +75,82 @@ type Client struct {
 	// 用于处理对相同资源的重复请求的并发控制
 	// 确保对于相同 key 的请求只有一个会执行实际的获取锁操作
 	g singleflight.Group
+	// 观察加锁过程中的事件
+	observer Observer
+	// 等待释放通知的人共用的订阅
+	unlocks unlockHub
+	// 公平锁等待者的心跳过期时间
+	fairHeartbeat time.Duration
+	// 拿到的锁按照这个时钟判断什么时候丢失，测试的时候可以换成 FakeClock
+	clock Clock
 }
 
-func NewClient(client redis.Cmdable) *Client {
-	return &Client{
-		client: client,
+func NewClient(client redis.Cmdable, opts ...ClientOption) *Client {
+	c := &Client{
+		client:        client,
+		observer:      noopObserver{},
+		fairHeartbeat: defaultFairHeartbeat,
+		clock:         realClock{},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 type Lock struct {
-	client     redis.Cmdable
+	// 加锁的 Locker，续约和释放都交给它
+	locker     Locker
 	key        string
 	value      string
 	expiration time.Duration
+	// fencing token，每次加锁成功都会得到一个单调递增的值
+	token      int64
 	unlockChan chan struct{}
+
+	// 锁丢失或者释放的时候被取消
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// 锁丢失的时候被关闭
+	lost     chan struct{}
+	lostOnce sync.Once
+	// 调用了 Unlock 之后为 true，之后续约失败不算丢失
+	released atomic.Bool
+
+	expiryMutex sync.Mutex
+	// 最后一次续约成功的续约发起时间，加锁成功也算一次
+	refreshedAt time.Time
+	// 到了 refreshedAt + expiration 还没有续约成功就标记为丢失
+	expiry Timer
+	// 计算 refreshedAt 和 expiry 用的时钟
+	clock Clock
+}
+
+// fencingKey 保存 key 对应的 fencing token 计数器
+// 这个 key 不会过期，否则 token 就不是单调递增的了。
+// 加锁的时候要在同一个 lua 脚本里面操作它和 key，所以要和 key 在同一个 slot
+func fencingKey(key string) string {
+	return hashTag(key) + ":fencing"
+}
+
+// hashTag 给 key 加上 hash tag，保证从 key 衍生出来的 key 在 redis cluster 里面落在同一个 slot，
+// 这样才能在同一个 lua 脚本里面一起操作，否则会报 CROSSSLOT。
+// key 本身已经带了 hash tag 的时候直接使用，衍生出来的 key 和 key 本身也在同一个 slot
+// 注意 key 里面有 '}' 但是没有合法的 hash tag 的时候（比如 "{}a"），没办法保证在同一个 slot
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key
+		}
+	}
+	return "{" + key + "}"
+}
+
+// Token 返回加锁时拿到的 fencing token
+// 锁过期之后被别人拿到，别人的 token 一定更大，
+// 下游存储可以拒绝 token 比已经见过的还小的写入
+func (l *Lock) Token() int64 {
+	return l.token
 }
 
 // SingleflightLock 是对 Lock 方法的包装，使用 singleflight 保证对相同 key 的并发请求只会有一个获得锁
@@ -90,115 +195,255 @@ func (c *Client) SingleflightLock(ctx context.Context,
 // expiration: 锁的过期时间，即锁被自动释放的时间。
 //timeout: 获取锁的超时时间，即尝试获取锁的最长等待时间。
 //retry: 重试策略接口，用于确定下一次重试的间隔和是否继续重试。
+// retry 是 retry.Policy 的时候，每次调用都会创建新的重试状态，同一个策略可以被多次加锁共用。
+// 第一次没有抢到锁之后会订阅锁的释放通知，锁一释放就马上重试；
+// 重试间隔只是兜底，防止通知丢失或者锁是自然过期的。
 func (c *Client) Lock(ctx context.Context, key string, expiration time.Duration, timeout time.Duration, retry RetryStrategy) (*Lock, error) {
-	var timer *time.Timer
-	val := uuid.New().String() // 生成唯一的锁值
-
-	for {
-		// 在这里重试
-		lctx, cancel := context.WithTimeout(ctx, timeout)
-
+	val := newLockValues(ctx) // 生成唯一的锁值，里面带上了持有者的信息
+	keys := []string{key, fencingKey(key)}
+	start := time.Now()
+	var token int64
+	var acquiredAt time.Time
+	err := retryLockWithWakeup(ctx, timeout, c.observeRetry(ctx, key, retry), c.unlockSubscriber(key), func(ctx context.Context) (bool, error) {
 		// 使用 Lua 脚本尝试获取锁
 		// 这边是有三种情况：
 		// 1.key 不存在
 		// 2.你上次加锁成功了但是返回超时了
 		// 3.锁被人家拿着
-		res, err := c.client.Eval(lctx, luaLock, []string{key}, val, expiration.Seconds()).Result()
-		cancel()
+		var err error
+		acquiredAt = c.clock.Now()
+		attemptStart := time.Now()
+		token, err = val.attempt(func(val string) (int64, error) {
+			return c.client.Eval(ctx, luaLock, keys, val, expiration.Milliseconds()).Int64()
+		})
+		c.observeAttempt(ctx, key, time.Since(attemptStart), token > 0, err)
+		return token > 0, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newLock(c, c.clock, key, val.val, expiration, token, acquiredAt), nil
+}
 
-		// 处理获取锁的结果和错误
-		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
-			return nil, err // 如果出现非超时错误，直接返回错误
-		}
+// retryLock 是各种锁共用的加锁循环
+// attempt 返回 true 表示加锁成功；每次尝试都使用 timeout 作为超时时间，
+// timeout 小于等于 0 的时候不单独设置超时。
+// 超时的尝试视为失败，然后按照重试策略等待下一次尝试
+func retryLock(ctx context.Context, timeout time.Duration, strategy RetryStrategy,
+	attempt func(ctx context.Context) (bool, error)) error {
+	return retryLockWithWakeup(ctx, timeout, strategy, nil, attempt)
+}
 
-		// 如果成功获取到锁，返回 Lock 实例
-		if res == "OK" {
-			return &Lock{
-				client:     c.client,
-				key:        key,
-				value:      val,
-				expiration: expiration,
-				unlockChan: make(chan struct{}, 1),
-			}, nil
+// retryLockWithWakeup 和 retryLock 一样，
+// 但是第一次尝试失败之后会调用 subscribe 订阅释放通知，等待重试的时候收到通知就立刻进行下一次尝试，
+// 不用等到重试间隔结束。没有竞争的时候一次就能加锁成功，不用付出订阅的开销。
+// subscribe 为 nil 或者返回 nil channel 的时候只按照重试策略重试
+func retryLockWithWakeup(ctx context.Context, timeout time.Duration, strategy RetryStrategy,
+	subscribe subscribeFunc, attempt func(ctx context.Context) (bool, error)) error {
+	wakeup := make(chan struct{}, 1)
+	unsubscribe := func() {}
+	defer func() {
+		unsubscribe()
+	}()
+	try := func(ctx context.Context) (bool, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 		}
+		return attempt(ctx)
+	}
 
-		// 根据重试策略获取下一次重试的间隔和是否继续重试的标志
-		interval, ok := retry.Next()
-
-		// 如果不允许继续重试，返回超出重试限制的错误
-		if !ok {
-			return nil, fmt.Errorf("redis-lock: 超出重试限制, %w", ErrFailedToPreemptLock)
+	// 超时之外的错误直接返回给调用方
+	var lastErr error
+	err := retry.Do(ctx, strategy, func(ctx context.Context) error {
+		ok, err := try(ctx)
+		if err == nil && !ok && subscribe != nil {
+			var msgs <-chan *redis.Message
+			msgs, unsubscribe = subscribe(ctx)
+			subscribe = nil
+			if msgs != nil {
+				go forwardWakeup(msgs, wakeup)
+				// 上一次尝试和订阅上之间锁可能已经被释放了，这个通知收不到，所以订阅之后马上再试一次
+				ok, err = try(ctx)
+			}
 		}
-
-		// 设置定时器，等待下一次重试
-		if timer == nil {
-			timer = time.NewTimer(interval)
-		} else {
-			timer.Reset(interval)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			lastErr = err
+			return retry.Permanent(err)
+		}
+		if !ok {
+			return ErrFailedToPreemptLock
 		}
+		return nil
+	}, retry.WithWakeup(wakeup))
+	if err == nil {
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return fmt.Errorf("redis-lock: 超出重试限制, %w", ErrFailedToPreemptLock)
+}
 
-		// 在定时器超时或者上下文被取消时，退出循环
+// forwardWakeup 把释放通知转发到 wakeup，msgs 被关闭的时候结束
+// 等待的人只关心有没有锁被释放，wakeup 里面已经有通知的时候丢掉新的通知
+func forwardWakeup(msgs <-chan *redis.Message, wakeup chan<- struct{}) {
+	for range msgs {
 		select {
-		case <-timer.C:
-			// 定时器触发，执行下一次重试
-		case <-ctx.Done():
-			// 上下文被取消，退出循环并返回错误
-			return nil, ctx.Err()
+		case wakeup <- struct{}{}:
+		default:
 		}
 	}
 }
 
+// cleanup 放弃加锁之后做清理，比如撤销排队、撤销等待登记或者释放已经加上的锁
+// 这里不能用调用方的 ctx，它可能已经被取消了
+func cleanup(timeout time.Duration, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout(timeout))
+	defer cancel()
+	fn(ctx)
+}
+
+// unlockTimeout 清理的超时时间，没有设置超时的时候用一秒
+func unlockTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return time.Second
+}
+
 // AutoRefresh 自动续约
 //自动刷新锁的过期时间，interval 表示刷新间隔，timeout 表示刷新的超时时间
+// 续约失败返回之后，锁会被标记为已经丢失，参考 Lost
 func (l *Lock) AutoRefresh(interval time.Duration, timeout time.Duration) error {
+	err := autoRefresh(l.clock, l.Refresh, l.unlockChan, interval, timeout, func() time.Time {
+		return l.lastRefreshed().Add(l.expiration)
+	})
+	if err != nil {
+		// 不再续约了，锁迟早会过期
+		l.markLost(err)
+	}
+	return err
+}
+
+// autoRefresh 按照 interval 周期性调用 refresh，直到 unlockChan 收到解锁通知
+// 各种锁的 AutoRefresh 都复用这个逻辑。validUntil 返回锁在 redis 上至少能保持到的时间，
+// 也就是最后一次成功续约（或者加锁）发起之前的时间加上过期时间，refresh 成功之后它要跟着更新。
+// 续约间隔和过期时间都按照 clock 计算，MemoryLocker 的锁用的是它自己的 Clock。
+// 除了 ErrLockNotHold 之外的错误都当作暂时的：慢的 redis 返回的可能是网络的 i/o timeout，
+// 主从切换的时候返回的是连接错误，这时候锁很可能还在。续约超时会马上重试，其他错误等下一个 interval 再试，
+// 但是到了 validUntil 还没有续约成功，锁肯定已经过期了，
+// 这时候马上返回 ErrLockNotHold，不会无限重试下去
+func autoRefresh(clock Clock, refresh func(ctx context.Context) error, unlockChan <-chan struct{},
+	interval time.Duration, timeout time.Duration, validUntil func() time.Time) error {
 	// 创建一个带缓冲通道，用于在超时时通知刷新
 	timeoutChan := make(chan struct{}, 1)
-	// 创建定时器，每隔 interval 时间触发一次
-	ticker := time.NewTicker(interval)
+	// 锁在 redis 上过期的时候收到通知，续约成功之后可能还残留着之前的通知，收到之后要再检查一次
+	expiredChan := make(chan struct{}, 1)
+	expired := clock.AfterFunc(validUntil().Sub(clock.Now()), func() {
+		select {
+		case expiredChan <- struct{}{}:
+		default:
+		}
+	})
+	defer expired.Stop()
+	errExpired := func(err error) error {
+		return fmt.Errorf("redis-lock: 续约一直失败，锁已经过期, %w, 最后一次的错误: %v", ErrLockNotHold, err)
+	}
+	var lastErr error
+
+	tryRefresh := func() error {
+		// 刷新的超时时间怎么设置
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := refresh(ctx)
+		cancel()
+		if err == nil {
+			expired.Reset(validUntil().Sub(clock.Now()))
+			return nil
+		}
+		if errors.Is(err, ErrLockNotHold) {
+			return err
+		}
+		lastErr = err
+		if !clock.Now().Before(validUntil()) {
+			return errExpired(err)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// 如果刷新超时，向 timeoutChan 发送通知
+			select {
+			case timeoutChan <- struct{}{}:
+			default:
+				// 已经有一次重试在排队了
+			}
+		}
+		return nil
+	}
 
 	// 无限循环，实现自动续约
+	tick := clock.After(interval)
 	for {
 		select {
-		case <-ticker.C:
+		case <-tick:
 			// 定时器触发，执行刷新操作
-			// 刷新的超时时间怎么设置
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			err := l.Refresh(ctx)
-			cancel()
-			if errors.Is(err, context.DeadlineExceeded) {
-				// 如果刷新超时，向 timeoutChan 发送通知
-				timeoutChan <- struct{}{}
-				continue
-			}
-			if err != nil {
-				// 如果刷新遇到其他错误，返回错误
+			tick = clock.After(interval)
+			if err := tryRefresh(); err != nil {
+				// 锁已经丢失，返回错误
 				return err
 			}
 		case <-timeoutChan:
 			// 从 timeoutChan 接收通知，执行刷新操作
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			// 出现了 error 了怎么办？
-			err := l.Refresh(ctx)
-			cancel()
-			if errors.Is(err, context.DeadlineExceeded) {
-				// 如果刷新超时，再次向 timeoutChan 发送通知
-				timeoutChan <- struct{}{}
-				continue
-			}
-			if err != nil {
-				// 如果刷新遇到其他错误，返回错误
+			if err := tryRefresh(); err != nil {
 				return err
 			}
-		case <-l.unlockChan:
+		case <-expiredChan:
+			// 到了过期时间还没有续约成功
+			if !clock.Now().Before(validUntil()) {
+				return errExpired(lastErr)
+			}
+		case <-unlockChan:
 			// 收到解锁通知，结束循环
 			return nil
 		}
 	}
 }
 
+// notifyUnlocked 通知 AutoRefresh 锁已经释放，不用再续约了，各种锁的释放都复用这个逻辑
+func notifyUnlocked(unlockChan chan<- struct{}) {
+	select {
+	case unlockChan <- struct{}{}:
+	default:
+		// 说明没有人调用 AutoRefresh，或者已经通知过了
+	}
+}
+
 // Refresh 续约 刷新锁的过期时间
 func (l *Lock) Refresh(ctx context.Context) error {
-	res, err := l.client.Eval(ctx, luaRefresh, []string{l.key}, l.value, l.expiration.Seconds()).Int64()
+	// 续约之前记下时间，续约成功的话锁至少能保持到这个时间加上 expiration
+	attemptStart := l.clock.Now()
+	err := l.locker.Refresh(ctx, l)
+	if err == nil {
+		l.extend(attemptStart)
+	} else if errors.Is(err, ErrLockNotHold) {
+		l.markLost(err)
+	}
+	return err
+}
+
+// Refresh 续约 刷新锁的过期时间
+func (c *Client) Refresh(ctx context.Context, l *Lock) error {
+	start := time.Now()
+	err := c.refresh(ctx, l)
+	observeRefresh(ctx, c.observer, l.key, time.Since(start), err)
+	return err
+}
+
+func (c *Client) refresh(ctx context.Context, l *Lock) error {
+	res, err := c.client.Eval(ctx, luaRefresh, []string{l.key}, l.value, l.expiration.Milliseconds()).Int64()
 	if err != nil {
 		return err
 	}
@@ -212,41 +457,50 @@ func (l *Lock) Refresh(ctx context.Context) error {
 
 // TryLock 尝试获取锁，如果锁未被其他协程持有，则成功获取锁，返回 Lock 实例，否则返回 ErrFailedToPreemptLock 错误。
 func (c *Client) TryLock(ctx context.Context, key string, expiration time.Duration) (*Lock, error) {
-	// 生成一个唯一的锁值
-	val := uuid.New().String()
+	// 生成一个唯一的锁值，里面带上了持有者的信息
+	val := newLockValue(ctx)
 
-	// 使用 SetNX 命令尝试设置锁，如果成功返回 true，表示锁未被其他协程持有
+	// 使用 Lua 脚本尝试设置锁，如果成功返回 fencing token，表示锁未被其他协程持有
 	// expiration 是过期时间
-	ok, err := c.client.SetNX(ctx, key, val, expiration).Result()
+	acquiredAt := c.clock.Now()
+	start := time.Now()
+	token, err := c.client.Eval(ctx, luaLock, []string{key, fencingKey(key)}, val, expiration.Milliseconds()).Int64()
+	c.observeAttempt(ctx, key, time.Since(start), token > 0, err)
 	if err != nil {
 		return nil, err // 发生错误时返回错误信息
 	}
 
-	// 如果 SetNX 返回 false，说明锁已被其他协程持有，返回 ErrFailedToPreemptLock 错误
-	if !ok {
+	// 如果返回 0，说明锁已被其他协程持有，返回 ErrFailedToPreemptLock 错误
+	if token <= 0 {
 		return nil, ErrFailedToPreemptLock
 	}
 
 	// 如果成功获取到锁，创建并返回 Lock 实例
-	return &Lock{
-		client:     c.client,               // 设置锁的 Redis 客户端
-		key:        key,                    // 锁的键
-		value:      val,                    // 锁的值，用于释放锁时校验
-		expiration: expiration,             // 锁的过期时间
-		unlockChan: make(chan struct{}, 1), // 创建用于通知释放锁的通道
-	}, nil
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newLock(c, c.clock, key, val, expiration, token, acquiredAt), nil
 }
 
 // Unlock 释放锁
 func (l *Lock) Unlock(ctx context.Context) error {
-	res, err := l.client.Eval(ctx, luaUnlock, []string{l.key}, l.value).Int64()
-	defer func() {
-		select {
-		case l.unlockChan <- struct{}{}:
-		default:
-			// 说明没有人调用 AutoRefresh
-		}
-	}()
+	// 先停掉自动续约再释放，否则正在进行的续约会发现锁已经不在了，把主动释放当成丢失
+	l.released.Store(true)
+	notifyUnlocked(l.unlockChan)
+	// 不管释放成功与否，都不应该再认为自己持有锁了
+	l.expiry.Stop()
+	defer l.cancel(ErrLockReleased)
+	return l.locker.Unlock(ctx, l)
+}
+
+// Unlock 释放锁，并且通知等待这把锁的人
+func (c *Client) Unlock(ctx context.Context, l *Lock) error {
+	start := time.Now()
+	err := c.unlock(ctx, l)
+	c.observe(ctx, EventUnlock, l.key, time.Since(start), err)
+	return err
+}
+
+func (c *Client) unlock(ctx context.Context, l *Lock) error {
+	res, err := c.client.Eval(ctx, luaUnlock, []string{l.key}, l.value, unlockChannel(l.key)).Int64()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,138 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFencingKey(t *testing.T) {
+	// 和锁的 key 在同一个 slot，不然在 redis cluster 上加锁会报 CROSSSLOT
+	assert.Equal(t, "{key1}:fencing", fencingKey("key1"))
+	assert.Equal(t, "{a}b:fencing", fencingKey("{a}b"))
+}
+
+func TestClient_Token(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	l1, err := c.TryLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	require.NoError(t, l1.Unlock(ctx))
+	l2, err := c.Lock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+	assert.Greater(t, l2.Token(), l1.Token())
+	token, err := mr.Get(fencingKey("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", token)
+}
+
+func TestClient_RefreshSubSecond(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	// 过期时间不是整数秒的时候也能续约
+	l, err := c.Lock(ctx, "key1", time.Millisecond*1500, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+	mr.FastForward(time.Second)
+	require.NoError(t, l.Refresh(ctx))
+	assert.Equal(t, time.Millisecond*1500, mr.TTL("key1"))
+
+	l, err = c.TryLock(ctx, "key2", time.Millisecond*500)
+	require.NoError(t, err)
+	require.NoError(t, l.Refresh(ctx))
+	assert.Equal(t, time.Millisecond*500, mr.TTL("key2"))
+
+	w, err := c.TryWLock(ctx, "key3", time.Millisecond*500)
+	require.NoError(t, err)
+	require.NoError(t, w.Refresh(ctx))
+	assert.Equal(t, time.Millisecond*500, mr.TTL(rwWriterKey("key3")))
+
+	mc, servers := newMultiClientForTest(t, 3, 0)
+	ml, err := mc.TryLock(ctx, "key4", time.Millisecond*1500)
+	require.NoError(t, err)
+	require.NoError(t, ml.Refresh(ctx))
+	for _, server := range servers {
+		assert.Equal(t, time.Millisecond*1500, server.TTL("key4"))
+	}
+}
+
+func TestClient_LockSubscribeLazily(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	// 没有竞争的时候不订阅释放通知
+	l, err := c.Lock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+	assert.Empty(t, mr.PubSubChannels(""))
+
+	// 有竞争的时候订阅，锁一释放就被唤醒，不用等重试间隔
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := c.Lock(ctx, "key1", time.Second*10, time.Second*5,
+			&FixedIntervalRetryStrategy{Interval: time.Second * 5, MaxCnt: 1})
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		return len(mr.PubSubChannels("")) == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, l.Unlock(ctx))
+	require.NoError(t, <-done)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_LockSharedSubscription(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	keys := []string{"key1", "key2"}
+	var locks []*Lock
+	for _, key := range keys {
+		l, err := c.TryLock(ctx, key, time.Second*10)
+		require.NoError(t, err)
+		locks = append(locks, l)
+	}
+
+	// 每个 key 三个等待者，拿到锁之后马上释放，唤醒下一个
+	const waiters = 3
+	done := make(chan error, len(keys)*waiters)
+	start := time.Now()
+	for _, key := range keys {
+		for i := 0; i < waiters; i++ {
+			go func(key string) {
+				// 每次被唤醒都算一次重试，同一个 key 的等待者会互相抢
+				l, err := c.Lock(ctx, key, time.Second*10, time.Second*5,
+					&FixedIntervalRetryStrategy{Interval: time.Second * 5, MaxCnt: waiters})
+				if err == nil {
+					err = l.Unlock(ctx)
+				}
+				done <- err
+			}(key)
+		}
+	}
+	// 所有等待者共用一个订阅，每个 channel 只订阅一次
+	require.Eventually(t, func() bool {
+		return len(mr.PubSubChannels("")) == len(keys)
+	}, time.Second, time.Millisecond)
+	for _, key := range keys {
+		assert.Equal(t, 1, mr.PubSubNumSub(unlockChannel(key))[unlockChannel(key)])
+	}
+
+	for _, l := range locks {
+		require.NoError(t, l.Unlock(ctx))
+	}
+	for i := 0; i < len(keys)*waiters; i++ {
+		require.NoError(t, <-done)
+	}
+	assert.Less(t, time.Since(start), time.Second*2)
+	// 等待者都走了之后退订
+	assert.Eventually(t, func() bool {
+		return len(mr.PubSubChannels("")) == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,139 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ReentrantLock 可重入锁
+// 同一个 owner 可以多次加锁，每次加锁都会返回一个 ReentrantLock，
+// 每个 ReentrantLock 都需要 Unlock 一次，全部释放之后 key 才会被删除
+type ReentrantLock struct {
+	client     redis.Cmdable
+	observer   Observer
+	key        string
+	owner      string // 持有者，同一个持有者可以重入
+	id         string // 本次加锁的唯一 id
+	expiration time.Duration
+	unlockChan chan struct{}
+	refreshed  refreshTime
+}
+
+// NewOwner 生成一个新的持有者标识
+// 一般是一个调用链路（比如一次请求）生成一次，然后在这个链路上传递
+func NewOwner() string {
+	return uuid.New().String()
+}
+
+// TryLockReentrant 尝试以 owner 的身份获取可重入锁
+// 如果锁没有被人持有，或者本来就是 owner 持有的，那么加锁成功，否则返回 ErrFailedToPreemptLock
+func (c *Client) TryLockReentrant(ctx context.Context, key string, owner string, expiration time.Duration) (*ReentrantLock, error) {
+	id := uuid.New().String()
+	start := time.Now()
+	ok, err := c.lockReentrant(ctx, key, owner, id, expiration)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrFailedToPreemptLock
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newReentrantLock(c, key, owner, id, expiration, start), nil
+}
+
+// LockReentrant 以 owner 的身份获取可重入锁，获取不到的时候按照重试策略进行重试
+// timeout: 每一次尝试加锁的超时时间
+func (c *Client) LockReentrant(ctx context.Context, key string, owner string,
+	expiration time.Duration, timeout time.Duration, retry RetryStrategy) (*ReentrantLock, error) {
+	// 整个加锁过程使用同一个 id，这样超时之后重试也不会多算一次重入
+	id := uuid.New().String()
+	start := time.Now()
+	var attemptStart time.Time
+	err := retryLock(ctx, timeout, c.observeRetry(ctx, key, retry), func(ctx context.Context) (bool, error) {
+		attemptStart = time.Now()
+		return c.lockReentrant(ctx, key, owner, id, expiration)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newReentrantLock(c, key, owner, id, expiration, attemptStart), nil
+}
+
+func (c *Client) lockReentrant(ctx context.Context, key, owner, id string, expiration time.Duration) (bool, error) {
+	start := time.Now()
+	res, err := c.client.Eval(ctx, luaReentrantLock, []string{key}, owner, id, expiration.Milliseconds()).Int64()
+	c.observeAttempt(ctx, key, time.Since(start), res > 0, err)
+	return res > 0, err
+}
+
+// newReentrantLock 创建可重入锁，start 是发起加锁之前的时间
+func newReentrantLock(c *Client, key, owner, id string, expiration time.Duration, start time.Time) *ReentrantLock {
+	l := &ReentrantLock{
+		client:     c.client,
+		observer:   c.observer,
+		key:        key,
+		owner:      owner,
+		id:         id,
+		expiration: expiration,
+		unlockChan: make(chan struct{}, 1),
+	}
+	l.refreshed.update(start)
+	return l
+}
+
+// Owner 返回持有者标识
+func (l *ReentrantLock) Owner() string {
+	return l.owner
+}
+
+// Refresh 续约，整个 key 的过期时间都会被刷新
+func (l *ReentrantLock) Refresh(ctx context.Context) error {
+	start := time.Now()
+	err := l.refresh(ctx)
+	if err == nil {
+		l.refreshed.update(start)
+	}
+	observeRefresh(ctx, l.observer, l.key, time.Since(start), err)
+	return err
+}
+
+func (l *ReentrantLock) refresh(ctx context.Context) error {
+	res, err := l.client.Eval(ctx, luaReentrantRefresh, []string{l.key}, l.owner, l.id, l.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+// AutoRefresh 自动续约，用法和 Lock.AutoRefresh 一样
+func (l *ReentrantLock) AutoRefresh(interval time.Duration, timeout time.Duration) error {
+	return autoRefresh(realClock{}, l.Refresh, l.unlockChan, interval, timeout, func() time.Time {
+		return l.refreshed.load().Add(l.expiration)
+	})
+}
+
+// Unlock 释放一次重入，最后一次释放的时候删除 key
+func (l *ReentrantLock) Unlock(ctx context.Context) error {
+	start := time.Now()
+	err := l.unlock(ctx)
+	observe(ctx, l.observer, EventUnlock, l.key, time.Since(start), err)
+	return err
+}
+
+func (l *ReentrantLock) unlock(ctx context.Context) error {
+	res, err := l.client.Eval(ctx, luaReentrantUnlock, []string{l.key}, l.owner, l.id).Int64()
+	defer notifyUnlocked(l.unlockChan)
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
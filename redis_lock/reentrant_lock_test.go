@@ -0,0 +1,61 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReentrantLock(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+	owner := NewOwner()
+
+	outer, err := c.TryLockReentrant(ctx, "key1", owner, time.Second*10)
+	require.NoError(t, err)
+	inner, err := c.LockReentrant(ctx, "key1", owner, time.Second, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	require.NoError(t, err)
+	// owner 字段加上两次加锁
+	fields, err := mr.HKeys("key1")
+	require.NoError(t, err)
+	assert.Len(t, fields, 3)
+	// 内层的过期时间更短，不能缩短外层的过期时间
+	assert.Equal(t, time.Second*10, mr.TTL("key1"))
+	require.NoError(t, inner.Refresh(ctx))
+	assert.Equal(t, time.Second*10, mr.TTL("key1"))
+
+	// 别人拿不到锁
+	_, err = c.TryLockReentrant(ctx, "key1", NewOwner(), time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+
+	// 释放一次之后还持有，同一次加锁不能释放两次
+	require.NoError(t, inner.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, inner.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, inner.Refresh(ctx))
+	assert.True(t, mr.Exists("key1"))
+
+	// 全部释放之后 key 被删除
+	require.NoError(t, outer.Unlock(ctx))
+	assert.False(t, mr.Exists("key1"))
+}
+
+func TestReentrantLock_NotOwner(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	l1, err := c.TryLockReentrant(ctx, "key1", NewOwner(), time.Second*10)
+	require.NoError(t, err)
+	// 锁过期之后被别人拿走
+	mr.FastForward(time.Second * 11)
+	l2, err := c.TryLockReentrant(ctx, "key1", NewOwner(), time.Second*10)
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrLockNotHold, l1.Refresh(ctx))
+	assert.Equal(t, ErrLockNotHold, l1.Unlock(ctx))
+	assert.True(t, mr.Exists("key1"))
+	require.NoError(t, l2.Unlock(ctx))
+	assert.False(t, mr.Exists("key1"))
+}
@@ -1,14 +1,21 @@
 package redis_lock
 
-import "time"
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/retry"
+	"time"
+)
 
 // RetryStrategy 定义了重试策略的接口
-type RetryStrategy interface {
-	// Next 返回下一次重试的间隔和是否继续重试的标志
-	Next() (time.Duration, bool)
-}
+// 和 retry.Strategy 是同一个类型，base/retry 里面的策略都可以直接传给 Lock，
+// 在很多实例同时抢锁的时候，推荐使用带抖动的策略，比如 retry.NewDecorrelatedJitterRetryStrategy
+type RetryStrategy = retry.Strategy
 
 // FixedIntervalRetryStrategy 实现了 RetryStrategy 接口，表示固定间隔的重试策略
+// 注意 MaxCnt 是 0 的时候表示不重试，
+// 和 retry.NewFixedIntervalRetryStrategy 相反，那边 maxRetries 是 0 表示无限重试
+//
+// Deprecated: 使用 retry.NewFixedIntervalRetryStrategy
 type FixedIntervalRetryStrategy struct {
 	Interval time.Duration // 重试的间隔
 	MaxCnt   int           // 最大重试次数
@@ -21,6 +28,25 @@ func (f *FixedIntervalRetryStrategy) Next() (time.Duration, bool) {
 	if f.cnt >= f.MaxCnt {
 		return 0, false
 	}
+	f.cnt++
 	// 返回重试的间隔和 true 表示继续重试
 	return f.Interval, true
 }
+
+// Start 创建一个新的重试状态，重试次数从 0 开始
+func (f *FixedIntervalRetryStrategy) Start() retry.Backoff {
+	return &FixedIntervalRetryStrategy{
+		Interval: f.Interval,
+		MaxCnt:   f.MaxCnt,
+	}
+}
+
+// Reset 重置重试次数
+func (f *FixedIntervalRetryStrategy) Reset() {
+	f.cnt = 0
+}
+
+// startRetry 开始一次新的重试，ctx 是这次加锁的 ctx
+func startRetry(ctx context.Context, s RetryStrategy) RetryStrategy {
+	return retry.StartContext(ctx, s)
+}
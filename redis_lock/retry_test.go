@@ -0,0 +1,18 @@
+package redis_lock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFixedIntervalRetryStrategy_Next(t *testing.T) {
+	s := &FixedIntervalRetryStrategy{Interval: time.Second, MaxCnt: 2}
+	for i := 0; i < 2; i++ {
+		interval, ok := s.Next()
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, interval)
+	}
+	_, ok := s.Next()
+	assert.False(t, ok)
+}
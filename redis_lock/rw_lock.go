@@ -0,0 +1,210 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// RWLock 读写锁
+// 读锁之间共享，写锁独占；有写者在等待的时候，新的读者不能加锁，避免写者饥饿
+// 读写锁在 redis 里面用到了三个 key，都带上了 hash tag，在 redis cluster 里面落在同一个 slot：
+// {<key>}:rw:writer 写锁，value 是写者的 id
+// {<key>}:rw:readers 读者集合，score 是每个读者自己的过期时间
+// {<key>}:rw:waiters 等待中的写者集合，score 是等待登记的过期时间
+type RWLock struct {
+	client     redis.Cmdable
+	observer   Observer
+	key        string
+	value      string
+	expiration time.Duration
+	// 是否是读锁
+	reader     bool
+	unlockChan chan struct{}
+	refreshed  refreshTime
+}
+
+func rwWriterKey(key string) string {
+	return hashTag(key) + ":rw:writer"
+}
+
+func rwReadersKey(key string) string {
+	return hashTag(key) + ":rw:readers"
+}
+
+func rwWaitersKey(key string) string {
+	return hashTag(key) + ":rw:waiters"
+}
+
+func rwKeys(key string) []string {
+	return []string{rwWriterKey(key), rwReadersKey(key), rwWaitersKey(key)}
+}
+
+// TryRLock 尝试获取读锁，写锁被人持有或者有写者在等待的时候返回 ErrFailedToPreemptLock
+func (c *Client) TryRLock(ctx context.Context, key string, expiration time.Duration) (*RWLock, error) {
+	val := uuid.New().String()
+	start := time.Now()
+	ok, err := c.rlock(ctx, key, val, expiration)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrFailedToPreemptLock
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newRWLock(c, key, val, expiration, true, start), nil
+}
+
+// RLock 获取读锁，获取不到的时候按照重试策略进行重试
+func (c *Client) RLock(ctx context.Context, key string, expiration time.Duration,
+	timeout time.Duration, retry RetryStrategy) (*RWLock, error) {
+	val := uuid.New().String()
+	start := time.Now()
+	var attemptStart time.Time
+	err := retryLock(ctx, timeout, c.observeRetry(ctx, key, retry), func(ctx context.Context) (bool, error) {
+		attemptStart = time.Now()
+		return c.rlock(ctx, key, val, expiration)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newRWLock(c, key, val, expiration, true, attemptStart), nil
+}
+
+// TryWLock 尝试获取写锁，有任何读者或者写者持有锁的时候返回 ErrFailedToPreemptLock
+func (c *Client) TryWLock(ctx context.Context, key string, expiration time.Duration) (*RWLock, error) {
+	val := uuid.New().String()
+	start := time.Now()
+	ok, err := c.wlock(ctx, key, val, expiration, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrFailedToPreemptLock
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newRWLock(c, key, val, expiration, false, start), nil
+}
+
+// WLock 获取写锁，获取不到的时候按照重试策略进行重试
+// 在等待期间会登记为等待中的写者，新的读者不能再加锁
+func (c *Client) WLock(ctx context.Context, key string, expiration time.Duration,
+	timeout time.Duration, retry RetryStrategy) (*RWLock, error) {
+	val := uuid.New().String()
+	start := time.Now()
+	var attemptStart time.Time
+	err := retryLock(ctx, timeout, c.observeRetry(ctx, key, retry), func(ctx context.Context) (bool, error) {
+		attemptStart = time.Now()
+		return c.wlock(ctx, key, val, expiration, true)
+	})
+	if err != nil {
+		// 放弃了就撤销等待登记，不然读者要等到登记过期才能进来
+		cleanup(timeout, func(ctx context.Context) {
+			c.client.ZRem(ctx, rwWaitersKey(key), val)
+		})
+		return nil, err
+	}
+	c.observe(ctx, EventAcquired, key, time.Since(start), nil)
+	return newRWLock(c, key, val, expiration, false, attemptStart), nil
+}
+
+func (c *Client) rlock(ctx context.Context, key string, val string, expiration time.Duration) (bool, error) {
+	start := time.Now()
+	res, err := c.client.Eval(ctx, luaRWRLock, rwKeys(key), val, expiration.Milliseconds()).Int64()
+	c.observeAttempt(ctx, key, time.Since(start), res == 1, err)
+	return res == 1, err
+}
+
+func (c *Client) wlock(ctx context.Context, key string, val string, expiration time.Duration, wait bool) (bool, error) {
+	waitFlag := 0
+	if wait {
+		waitFlag = 1
+	}
+	start := time.Now()
+	res, err := c.client.Eval(ctx, luaRWWLock, rwKeys(key), val, expiration.Milliseconds(), waitFlag).Int64()
+	c.observeAttempt(ctx, key, time.Since(start), res == 1, err)
+	return res == 1, err
+}
+
+// newRWLock 创建读写锁，start 是发起加锁之前的时间
+func newRWLock(c *Client, key, val string, expiration time.Duration, reader bool, start time.Time) *RWLock {
+	l := &RWLock{
+		client:     c.client,
+		observer:   c.observer,
+		key:        key,
+		value:      val,
+		expiration: expiration,
+		reader:     reader,
+		unlockChan: make(chan struct{}, 1),
+	}
+	l.refreshed.update(start)
+	return l
+}
+
+// IsReader 是否是读锁
+func (l *RWLock) IsReader() bool {
+	return l.reader
+}
+
+// Refresh 续约，读者和写者各自续约自己的过期时间
+func (l *RWLock) Refresh(ctx context.Context) error {
+	start := time.Now()
+	err := l.refresh(ctx)
+	if err == nil {
+		l.refreshed.update(start)
+	}
+	observeRefresh(ctx, l.observer, l.key, time.Since(start), err)
+	return err
+}
+
+func (l *RWLock) refresh(ctx context.Context) error {
+	var res int64
+	var err error
+	if l.reader {
+		res, err = l.client.Eval(ctx, luaZSetRefresh, []string{rwReadersKey(l.key)}, l.value, l.expiration.Milliseconds()).Int64()
+	} else {
+		res, err = l.client.Eval(ctx, luaRefresh, []string{rwWriterKey(l.key)}, l.value, l.expiration.Milliseconds()).Int64()
+	}
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
+
+// AutoRefresh 自动续约，用法和 Lock.AutoRefresh 一样
+func (l *RWLock) AutoRefresh(interval time.Duration, timeout time.Duration) error {
+	return autoRefresh(realClock{}, l.Refresh, l.unlockChan, interval, timeout, func() time.Time {
+		return l.refreshed.load().Add(l.expiration)
+	})
+}
+
+// Unlock 释放锁
+func (l *RWLock) Unlock(ctx context.Context) error {
+	start := time.Now()
+	err := l.unlock(ctx)
+	observe(ctx, l.observer, EventUnlock, l.key, time.Since(start), err)
+	return err
+}
+
+func (l *RWLock) unlock(ctx context.Context) error {
+	var res int64
+	var err error
+	if l.reader {
+		res, err = l.client.Eval(ctx, luaZSetRelease, []string{rwReadersKey(l.key)}, l.value).Int64()
+	} else {
+		res, err = l.client.Eval(ctx, luaUnlock, []string{rwWriterKey(l.key)}, l.value).Int64()
+	}
+	defer notifyUnlocked(l.unlockChan)
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
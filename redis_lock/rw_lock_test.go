@@ -0,0 +1,123 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRWLock_Keys(t *testing.T) {
+	// 三个 key 的 hash tag 一样，在 redis cluster 里面落在同一个 slot
+	assert.Equal(t, []string{"{key1}:rw:writer", "{key1}:rw:readers", "{key1}:rw:waiters"}, rwKeys("key1"))
+	assert.Equal(t, []string{"{a}b:rw:writer", "{a}b:rw:readers", "{a}b:rw:waiters"}, rwKeys("{a}b"))
+}
+
+func TestRWLock_WriterBlocksReaders(t *testing.T) {
+	c, _ := newClientForTest(t)
+	ctx := context.Background()
+
+	w, err := c.TryWLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	assert.False(t, w.IsReader())
+	_, err = c.TryRLock(ctx, "key1", time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+	_, err = c.TryWLock(ctx, "key1", time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+
+	require.NoError(t, w.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHold, w.Unlock(ctx))
+	// 读者之间共享
+	r1, err := c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	assert.True(t, r1.IsReader())
+	r2, err := c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	// 有读者的时候写者拿不到锁
+	_, err = c.TryWLock(ctx, "key1", time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+	require.NoError(t, r1.Unlock(ctx))
+	require.NoError(t, r2.Unlock(ctx))
+	_, err = c.TryWLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+}
+
+func TestRWLock_WriterPreference(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	r, err := c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+
+	done := make(chan *RWLock, 1)
+	go func() {
+		w, err := c.WLock(ctx, "key1", time.Second*10, time.Second,
+			&FixedIntervalRetryStrategy{Interval: time.Millisecond * 10, MaxCnt: 100})
+		assert.NoError(t, err)
+		done <- w
+	}()
+	// 等到写者登记之后，新的读者进不来，已有的读者还可以续约
+	require.Eventually(t, func() bool {
+		return mr.Exists(rwWaitersKey("key1"))
+	}, time.Second, time.Millisecond)
+	_, err = c.TryRLock(ctx, "key1", time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+	require.NoError(t, r.Refresh(ctx))
+
+	require.NoError(t, r.Unlock(ctx))
+	var w *RWLock
+	select {
+	case w = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("写者没有拿到锁")
+	}
+	require.NotNil(t, w)
+	// 拿到锁之后撤销了等待登记
+	members, _ := mr.ZMembers(rwWaitersKey("key1"))
+	assert.Empty(t, members)
+	require.NoError(t, w.Unlock(ctx))
+	_, err = c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+}
+
+func TestRWLock_WLockGiveUp(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+
+	_, err := c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+	_, err = c.WLock(ctx, "key1", time.Second*10, time.Second,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 2})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+	// 放弃之后撤销等待登记，读者可以进来
+	members, _ := mr.ZMembers(rwWaitersKey("key1"))
+	assert.Empty(t, members)
+	_, err = c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+}
+
+func TestRWLock_ReaderExpiry(t *testing.T) {
+	c, mr := newClientForTest(t)
+	ctx := context.Background()
+	now := time.Now()
+	mr.SetTime(now)
+
+	r1, err := c.TryRLock(ctx, "key1", time.Second)
+	require.NoError(t, err)
+	_, err = c.TryRLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+
+	// 第一个读者过期了，第二个读者还在，写者还是拿不到锁
+	mr.SetTime(now.Add(time.Second * 2))
+	assert.Equal(t, ErrLockNotHold, r1.Refresh(ctx))
+	// 过期的读者释放的时候也要报告锁已经不在了
+	assert.Equal(t, ErrLockNotHold, r1.Unlock(ctx))
+	_, err = c.TryWLock(ctx, "key1", time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+
+	// 全部读者都过期了，挂掉的读者不会一直挡住写者
+	mr.SetTime(now.Add(time.Second * 11))
+	_, err = c.TryWLock(ctx, "key1", time.Second*10)
+	require.NoError(t, err)
+}
@@ -0,0 +1,120 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Semaphore 分布式信号量，同一个 key 最多允许 permits 个持有者
+// 持有者保存在 redis 的 zset 里面，score 是持有者的过期时间，
+// 每次获取的时候都会先清理掉过期的持有者。
+// 信号量目前不会给 Observer 发事件
+type Semaphore struct {
+	client redis.Cmdable
+}
+
+func NewSemaphore(client redis.Cmdable) *Semaphore {
+	return &Semaphore{
+		client: client,
+	}
+}
+
+// Permit 代表拿到的一个名额
+type Permit struct {
+	client     redis.Cmdable
+	key        string
+	value      string
+	expiration time.Duration
+	unlockChan chan struct{}
+	refreshed  refreshTime
+}
+
+// TryAcquire 尝试获取一个名额，名额已经用完的时候返回 ErrFailedToPreemptLock
+func (s *Semaphore) TryAcquire(ctx context.Context, key string, permits int, expiration time.Duration) (*Permit, error) {
+	if permits < 1 {
+		return nil, common.NewErrInvalidArgument("permits", permits)
+	}
+	val := uuid.New().String()
+	start := time.Now()
+	ok, err := s.acquire(ctx, key, val, permits, expiration)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrFailedToPreemptLock
+	}
+	return s.newPermit(key, val, expiration, start), nil
+}
+
+// Acquire 获取一个名额，名额已经用完的时候按照重试策略进行重试
+func (s *Semaphore) Acquire(ctx context.Context, key string, permits int,
+	expiration time.Duration, retry RetryStrategy) (*Permit, error) {
+	if permits < 1 {
+		return nil, common.NewErrInvalidArgument("permits", permits)
+	}
+	val := uuid.New().String()
+	var attemptStart time.Time
+	err := retryLock(ctx, 0, retry, func(ctx context.Context) (bool, error) {
+		attemptStart = time.Now()
+		return s.acquire(ctx, key, val, permits, expiration)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.newPermit(key, val, expiration, attemptStart), nil
+}
+
+func (s *Semaphore) acquire(ctx context.Context, key string, val string, permits int, expiration time.Duration) (bool, error) {
+	res, err := s.client.Eval(ctx, luaSemaphoreAcquire, []string{key}, val, permits, expiration.Milliseconds()).Int64()
+	return res == 1, err
+}
+
+// newPermit 创建名额，start 是发起获取之前的时间
+func (s *Semaphore) newPermit(key string, val string, expiration time.Duration, start time.Time) *Permit {
+	p := &Permit{
+		client:     s.client,
+		key:        key,
+		value:      val,
+		expiration: expiration,
+		unlockChan: make(chan struct{}, 1),
+	}
+	p.refreshed.update(start)
+	return p
+}
+
+// Refresh 续约
+func (p *Permit) Refresh(ctx context.Context) error {
+	start := time.Now()
+	res, err := p.client.Eval(ctx, luaZSetRefresh, []string{p.key}, p.value, p.expiration.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	p.refreshed.update(start)
+	return nil
+}
+
+// AutoRefresh 自动续约，用法和 Lock.AutoRefresh 一样
+func (p *Permit) AutoRefresh(interval time.Duration, timeout time.Duration) error {
+	return autoRefresh(realClock{}, p.Refresh, p.unlockChan, interval, timeout, func() time.Time {
+		return p.refreshed.load().Add(p.expiration)
+	})
+}
+
+// Release 归还名额
+func (p *Permit) Release(ctx context.Context) error {
+	res, err := p.client.Eval(ctx, luaZSetRelease, []string{p.key}, p.value).Int64()
+	defer notifyUnlocked(p.unlockChan)
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrLockNotHold
+	}
+	return nil
+}
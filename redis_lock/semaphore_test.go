@@ -0,0 +1,91 @@
+package redis_lock
+
+import (
+	"context"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSemaphore_Permits(t *testing.T) {
+	c, _ := newClientForTest(t)
+	s := NewSemaphore(c.client)
+	ctx := context.Background()
+
+	p1, err := s.TryAcquire(ctx, "sem1", 2, time.Second*10)
+	require.NoError(t, err)
+	_, err = s.TryAcquire(ctx, "sem1", 2, time.Second*10)
+	require.NoError(t, err)
+	// 名额用完了
+	_, err = s.TryAcquire(ctx, "sem1", 2, time.Second*10)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+	// 不同的 key 互不影响
+	_, err = s.TryAcquire(ctx, "sem2", 2, time.Second*10)
+	require.NoError(t, err)
+
+	// 归还之后别人可以拿到，同一个名额不能归还两次
+	require.NoError(t, p1.Release(ctx))
+	assert.Equal(t, ErrLockNotHold, p1.Release(ctx))
+	assert.Equal(t, ErrLockNotHold, p1.Refresh(ctx))
+	_, err = s.TryAcquire(ctx, "sem1", 2, time.Second*10)
+	require.NoError(t, err)
+}
+
+func TestSemaphore_InvalidPermits(t *testing.T) {
+	c, _ := newClientForTest(t)
+	s := NewSemaphore(c.client)
+	ctx := context.Background()
+
+	_, err := s.TryAcquire(ctx, "sem1", 0, time.Second*10)
+	assert.Equal(t, common.NewErrInvalidArgument("permits", 0), err)
+	_, err = s.Acquire(ctx, "sem1", -1, time.Second*10, &FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 1})
+	assert.Equal(t, common.NewErrInvalidArgument("permits", -1), err)
+}
+
+func TestSemaphore_Acquire(t *testing.T) {
+	c, _ := newClientForTest(t)
+	s := NewSemaphore(c.client)
+	ctx := context.Background()
+
+	p, err := s.TryAcquire(ctx, "sem1", 1, time.Second*10)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = p.Release(ctx)
+	}()
+	_, err = s.Acquire(ctx, "sem1", 1, time.Second*10,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond * 10, MaxCnt: 100})
+	require.NoError(t, err)
+
+	_, err = s.Acquire(ctx, "sem1", 1, time.Second*10,
+		&FixedIntervalRetryStrategy{Interval: time.Millisecond, MaxCnt: 2})
+	assert.ErrorIs(t, err, ErrFailedToPreemptLock)
+}
+
+func TestSemaphore_Expiry(t *testing.T) {
+	c, mr := newClientForTest(t)
+	s := NewSemaphore(c.client)
+	ctx := context.Background()
+	now := time.Now()
+	mr.SetTime(now)
+
+	dead, err := s.TryAcquire(ctx, "sem1", 2, time.Second)
+	require.NoError(t, err)
+	alive, err := s.TryAcquire(ctx, "sem1", 2, time.Second)
+	require.NoError(t, err)
+
+	// 只有 alive 在续约，崩溃的持有者过期之后名额会被回收
+	mr.SetTime(now.Add(time.Millisecond * 800))
+	require.NoError(t, alive.Refresh(ctx))
+	mr.SetTime(now.Add(time.Millisecond * 1500))
+	// 还没有人清理过期的持有者，归还的时候也要发现名额已经没了
+	assert.Equal(t, ErrLockNotHold, dead.Release(ctx))
+	assert.Equal(t, ErrLockNotHold, dead.Refresh(ctx))
+	_, err = s.TryAcquire(ctx, "sem1", 2, time.Second)
+	require.NoError(t, err)
+	_, err = s.TryAcquire(ctx, "sem1", 2, time.Second)
+	assert.Equal(t, ErrFailedToPreemptLock, err)
+	require.NoError(t, alive.Release(ctx))
+}
@@ -0,0 +1,30 @@
+-- GCRA（通用信元速率算法）
+-- 只保存一个理论到达时间（TAT），每个请求把 TAT 往后推一个发射间隔，
+-- TAT 超出当前时间太多就说明请求太快了
+-- KEYS[1] 限流的 key，保存 TAT，单位微秒
+-- ARGV[1] 发射间隔，也就是 period / rate，单位微秒
+-- ARGV[2] 允许的突发，也就是 发射间隔 * burst，单位微秒
+-- ARGV[3] 这次请求的数量
+-- 返回 {是否通过, 剩余的配额, 多久之后可以重试（微秒）, 多久之后完全恢复（微秒）}
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local emission = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('get', KEYS[1]))
+if tat == nil or tat < now then
+    tat = now
+end
+
+local new_tat = tat + emission * n
+local diff = new_tat - now
+if diff > tolerance then
+    --    超过了允许的突发，拒绝
+    local remaining = math.floor((tolerance - (tat - now)) / emission)
+    return {0, remaining, diff - tolerance, tat - now}
+end
+
+-- 数字直接传给 redis.call 会被转成科学计数法，丢失精度
+redis.call('set', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil(diff / 1000))
+return {1, math.floor((tolerance - diff) / emission), 0, diff}
@@ -0,0 +1,43 @@
+-- 滑动窗口，用 zset 记录窗口里面每一个请求的时间，结果是精确的
+-- KEYS[1] 限流的 key
+-- ARGV[1] 窗口大小，单位微秒
+-- ARGV[2] 窗口里面最多的请求数量
+-- ARGV[3] 这次请求的数量
+-- ARGV[4] 这次请求的唯一 id，用来生成 zset 的 member
+-- 返回 {是否通过, 剩余的配额, 多久之后可以重试（微秒）, 多久之后完全恢复（微秒）}
+local t = redis.call('time')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+-- 数字直接传给 redis.call 会被转成科学计数法，丢失精度
+redis.call('zremrangebyscore', KEYS[1], '-inf', string.format('%.0f', now - window))
+local count = redis.call('zcard', KEYS[1])
+
+-- 最后一个请求滑出窗口之后就完全恢复了
+local function reset_after()
+    local newest = redis.call('zrange', KEYS[1], -1, -1, 'WITHSCORES')
+    if newest[2] == nil then
+        return 0
+    end
+    return tonumber(newest[2]) + window - now
+end
+
+if count + n > limit then
+    --    要等到最早的 count + n - limit 个请求滑出窗口
+    local idx = count + n - limit - 1
+    local oldest = redis.call('zrange', KEYS[1], idx, idx, 'WITHSCORES')
+    local retry_after = 0
+    if oldest[2] ~= nil then
+        retry_after = tonumber(oldest[2]) + window - now
+    end
+    return {0, limit - count, retry_after, reset_after()}
+end
+
+local score = string.format('%.0f', now)
+for i = 1, n do
+    redis.call('zadd', KEYS[1], score, ARGV[4] .. ':' .. i)
+end
+redis.call('pexpire', KEYS[1], math.ceil(window / 1000))
+return {1, limit - count - n, 0, reset_after()}
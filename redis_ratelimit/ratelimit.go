@@ -0,0 +1,218 @@
+package redis_ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"github.com/colin-water/go_tool_libaray/base/common"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	//go:embed lua/gcra.lua
+	luaGCRA string
+
+	//go:embed lua/sliding_window.lua
+	luaSlidingWindow string
+)
+
+// Limit 限流的额度，Period 时间里面最多 Rate 个请求
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst 允许的突发请求数量，只对 GCRA 有效，0 的时候等于 Rate
+	Burst int
+}
+
+// PerSecond 每秒 n 个请求
+func PerSecond(n int) Limit {
+	return Limit{Rate: n, Period: time.Second}
+}
+
+// PerMinute 每分钟 n 个请求
+func PerMinute(n int) Limit {
+	return Limit{Rate: n, Period: time.Minute}
+}
+
+// PerHour 每小时 n 个请求
+func PerHour(n int) Limit {
+	return Limit{Rate: n, Period: time.Hour}
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 {
+		return common.NewErrInvalidArgument("rate", l.Rate)
+	}
+	if l.Period <= 0 {
+		return common.NewErrInvalidIntervalValue(l.Period)
+	}
+	if l.Burst < 0 {
+		return common.NewErrInvalidArgument("burst", l.Burst)
+	}
+	return nil
+}
+
+// Result 限流的结果
+type Result struct {
+	// Allowed 请求是否通过
+	Allowed bool
+	// Remaining 剩余的配额，redis 不可用的时候是 -1
+	Remaining int64
+	// RetryAfter 被拒绝的时候，多久之后可以重试
+	RetryAfter time.Duration
+	// ResetAfter 多久之后配额完全恢复
+	ResetAfter time.Duration
+}
+
+// FailPolicy redis 不可用的时候怎么处理
+type FailPolicy int
+
+const (
+	// FailOpen 放行所有请求，限流不可用不影响业务
+	FailOpen FailPolicy = iota
+	// FailClosed 拒绝所有请求，保护下游
+	FailClosed
+)
+
+// Limiter 分布式限流器
+// redis 出错的时候，Result 按照 FailPolicy 填充，同时也会返回错误，
+// 所以调用方可以只看 Result.Allowed，错误只用来记录日志
+type Limiter interface {
+	// Allow 一个请求能不能通过
+	Allow(ctx context.Context, key string) (Result, error)
+	// AllowN n 个请求能不能同时通过，不能的时候一个都不会通过
+	AllowN(ctx context.Context, key string, n int) (Result, error)
+}
+
+type options struct {
+	prefix string
+	policy FailPolicy
+}
+
+// Option 限流器的可选配置
+type Option func(o *options)
+
+// WithPrefix 设置 key 的前缀，默认是 "redis-ratelimit:"
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithFailPolicy 设置 redis 不可用的时候的处理方式，默认是 FailOpen
+func WithFailPolicy(policy FailPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{prefix: "redis-ratelimit:", policy: FailOpen}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// GCRA 基于 GCRA 算法的限流器
+// 每个 key 只保存一个时间戳，请求之间的间隔是均匀的，并且允许 Burst 大小的突发
+type GCRA struct {
+	client    redis.Cmdable
+	opts      options
+	burst     int
+	emission  time.Duration
+	tolerance time.Duration
+}
+
+// NewGCRA 创建基于 GCRA 算法的限流器
+func NewGCRA(client redis.Cmdable, limit Limit, opts ...Option) (*GCRA, error) {
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	burst := limit.Burst
+	if burst == 0 {
+		burst = limit.Rate
+	}
+	emission := limit.Period / time.Duration(limit.Rate)
+	if emission < time.Microsecond {
+		// 脚本里面的精度是微秒
+		return nil, common.NewErrInvalidIntervalValue(emission)
+	}
+	return &GCRA{
+		client:    client,
+		opts:      newOptions(opts),
+		burst:     burst,
+		emission:  emission,
+		tolerance: emission * time.Duration(burst),
+	}, nil
+}
+
+func (g *GCRA) Allow(ctx context.Context, key string) (Result, error) {
+	return g.AllowN(ctx, key, 1)
+}
+
+func (g *GCRA) AllowN(ctx context.Context, key string, n int) (Result, error) {
+	if n <= 0 || n > g.burst {
+		return Result{}, common.NewErrInvalidArgument("n", n)
+	}
+	res, err := g.client.Eval(ctx, luaGCRA, []string{g.opts.prefix + key},
+		g.emission.Microseconds(), g.tolerance.Microseconds(), n).Int64Slice()
+	return toResult(res, err, g.opts.policy)
+}
+
+// SlidingWindow 基于滑动窗口的限流器
+// 任意 Period 长度的时间里面最多 Rate 个请求，结果是精确的，但是每个 key 要保存 Rate 个请求的时间
+type SlidingWindow struct {
+	client redis.Cmdable
+	opts   options
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindow 创建基于滑动窗口的限流器
+func NewSlidingWindow(client redis.Cmdable, limit Limit, opts ...Option) (*SlidingWindow, error) {
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	if limit.Period < time.Microsecond {
+		return nil, common.NewErrInvalidIntervalValue(limit.Period)
+	}
+	return &SlidingWindow{
+		client: client,
+		opts:   newOptions(opts),
+		limit:  limit.Rate,
+		window: limit.Period,
+	}, nil
+}
+
+func (s *SlidingWindow) Allow(ctx context.Context, key string) (Result, error) {
+	return s.AllowN(ctx, key, 1)
+}
+
+func (s *SlidingWindow) AllowN(ctx context.Context, key string, n int) (Result, error) {
+	if n <= 0 || n > s.limit {
+		return Result{}, common.NewErrInvalidArgument("n", n)
+	}
+	res, err := s.client.Eval(ctx, luaSlidingWindow, []string{s.opts.prefix + key},
+		s.window.Microseconds(), s.limit, n, uuid.New().String()).Int64Slice()
+	return toResult(res, err, s.opts.policy)
+}
+
+// toResult 把脚本的返回值转换成 Result，出错的时候按照 policy 处理
+func toResult(res []int64, err error, policy FailPolicy) (Result, error) {
+	if err != nil {
+		return Result{Allowed: policy == FailOpen, Remaining: -1}, err
+	}
+	return Result{
+		Allowed:    res[0] == 1,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * time.Microsecond,
+		ResetAfter: time.Duration(res[3]) * time.Microsecond,
+	}, nil
+}
+
+var (
+	_ Limiter = &GCRA{}
+	_ Limiter = &SlidingWindow{}
+)
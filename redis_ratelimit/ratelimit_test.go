@@ -0,0 +1,118 @@
+package redis_ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newRedisForTest(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Now())
+	return mr, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestGCRA_Allow(t *testing.T) {
+	mr, client := newRedisForTest(t)
+	now := time.Now()
+	mr.SetTime(now)
+	l, err := NewGCRA(client, Limit{Rate: 10, Period: time.Second, Burst: 3})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 突发 3 个
+	for i := 2; i >= 0; i-- {
+		res, err := l.Allow(ctx, "tenant1")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, int64(i), res.Remaining)
+	}
+	res, err := l.Allow(ctx, "tenant1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+	assert.Equal(t, time.Millisecond*100, res.RetryAfter)
+	assert.Equal(t, time.Millisecond*300, res.ResetAfter)
+
+	// 不同的 key 互不影响
+	res, err = l.Allow(ctx, "tenant2")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// 过了一个发射间隔之后又可以通过一个
+	mr.SetTime(now.Add(time.Millisecond * 100))
+	res, err = l.Allow(ctx, "tenant1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = l.Allow(ctx, "tenant1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 一次要的太多
+	_, err = l.AllowN(ctx, "tenant3", 4)
+	assert.Error(t, err)
+}
+
+func TestSlidingWindow_Allow(t *testing.T) {
+	mr, client := newRedisForTest(t)
+	now := time.Now()
+	mr.SetTime(now)
+	l, err := NewSlidingWindow(client, PerMinute(3))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	res, err := l.AllowN(ctx, "tenant1", 2)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(1), res.Remaining)
+
+	mr.SetTime(now.Add(time.Second * 30))
+	res, err = l.Allow(ctx, "tenant1")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+	assert.Equal(t, time.Minute, res.ResetAfter)
+
+	res, err = l.Allow(ctx, "tenant1")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	// 要等到最早的两个请求滑出窗口
+	assert.Equal(t, time.Second*30, res.RetryAfter)
+
+	mr.SetTime(now.Add(time.Minute + time.Millisecond))
+	res, err = l.AllowN(ctx, "tenant1", 2)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+}
+
+func TestLimiter_FailPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      FailPolicy
+		wantAllowed bool
+	}{
+		{name: "fail open", policy: FailOpen, wantAllowed: true},
+		{name: "fail closed", policy: FailClosed, wantAllowed: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, client := newRedisForTest(t)
+			mr.SetError("redis 不可用")
+			gcra, err := NewGCRA(client, PerSecond(10), WithFailPolicy(tc.policy))
+			require.NoError(t, err)
+			sw, err := NewSlidingWindow(client, PerSecond(10), WithFailPolicy(tc.policy))
+			require.NoError(t, err)
+			for _, l := range []Limiter{gcra, sw} {
+				res, err := l.Allow(context.Background(), "tenant1")
+				assert.Error(t, err)
+				assert.Equal(t, tc.wantAllowed, res.Allowed)
+				assert.Equal(t, int64(-1), res.Remaining)
+			}
+		})
+	}
+}